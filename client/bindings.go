@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Keys of the normal mode commands, by command. Several keys are separated by spaces.
+// The config file's "keys" section replaces the keys of the commands it names.
+var defaultKeyBindings = map[string]string{
+	"url":           "u",
+	"edit-url":      "Ctrl+L",
+	"back":          "Backspace",
+	"forward":       "Shift+Backspace",
+	"home":          "h Alt+Home",
+	"reload":        "r",
+	"hard-reload":   "R",
+	"stop":          "s",
+	"new-tab":       "t",
+	"close-tab":     "x",
+	"prev-tab":      "J",
+	"next-tab":      "K",
+	"insert":        "i",
+	"next-renderer": "F2",
+	"quit":          "Ctrl+Q",
+}
+
+// keyChord is a key together with its modifiers, the way a command is bound to it
+type keyChord struct {
+	key  tcell.Key
+	r    rune // The character for tcell.KeyRune
+	mods tcell.ModMask
+}
+
+// chordFromEvent returns the chord of a key press. Terminals report the same keys in different ways,
+// e.g. Ctrl+L as a control character or as 'l' with ModCtrl; both give the same chord.
+func chordFromEvent(ev *tcell.EventKey) keyChord {
+	key, r, mods := ev.Key(), ev.Rune(), ev.Modifiers()
+	_, named := tcellKeys[key]
+	switch {
+	case key == tcell.KeyBackspace:
+		key = tcell.KeyBackspace2
+	case key == tcell.KeyBacktab:
+		key, mods = tcell.KeyTab, mods|tcell.ModShift
+	case key >= tcell.KeyCtrlA && key <= tcell.KeyCtrlZ && !named:
+		key, r, mods = tcell.KeyRune, rune('a'+key-tcell.KeyCtrlA), mods|tcell.ModCtrl
+	}
+	if key == tcell.KeyRune {
+		return runeChord(r, mods)
+	}
+	return keyChord{key: key, mods: mods}
+}
+
+// runeChord returns the chord of a typed character. The character itself says whether Shift was held.
+func runeChord(r rune, mods tcell.ModMask) keyChord {
+	mods &^= tcell.ModShift
+	if mods&tcell.ModCtrl != 0 {
+		r = unicode.ToLower(r)
+	}
+	return keyChord{key: tcell.KeyRune, r: r, mods: mods}
+}
+
+// parseKeyChord parses a key as written in the config file: modifiers joined with '+' followed by a
+// character or a browser key name, e.g. "r", "Ctrl+L", "Alt+Home", "Shift+Backspace" or "F5"
+func parseKeyChord(spec string) (keyChord, error) {
+	if spec == "" {
+		return keyChord{}, fmt.Errorf("empty key")
+	}
+	name := spec
+	var modNames []string
+	// The last '+' can't separate anything, it is the key itself in "Ctrl++"
+	if i := strings.LastIndex(spec[:len(spec)-1], "+"); i >= 0 {
+		modNames = strings.Split(spec[:i], "+")
+		name = spec[i+1:]
+	}
+
+	var mods tcell.ModMask
+	for _, mod := range modNames {
+		switch strings.ToLower(mod) {
+		case "ctrl":
+			mods |= tcell.ModCtrl
+		case "alt":
+			mods |= tcell.ModAlt
+		case "meta":
+			mods |= tcell.ModMeta
+		case "shift":
+			mods |= tcell.ModShift
+		default:
+			return keyChord{}, fmt.Errorf("unknown modifier %q in %q (expected Ctrl, Alt, Meta or Shift)", mod, spec)
+		}
+	}
+
+	if utf8.RuneCountInString(name) == 1 {
+		r, _ := utf8.DecodeRuneInString(name)
+		if mods&tcell.ModShift != 0 {
+			r = unicode.ToUpper(r)
+		}
+		return runeChord(r, mods), nil
+	}
+	for key, dk := range tcellKeys {
+		// Backspace and Tab have two tcell keys each; chordFromEvent maps both to these
+		if key == tcell.KeyBackspace || key == tcell.KeyBacktab || utf8.RuneCountInString(dk.key) == 1 {
+			continue
+		}
+		if strings.EqualFold(dk.key, name) {
+			return keyChord{key: key, mods: mods}, nil
+		}
+	}
+	return keyChord{}, fmt.Errorf("unknown key %q in %q", name, spec)
+}
+
+// bindKeys builds the key bindings from the defaults and the config file's "keys" section
+func bindKeys(keys map[string]string) (map[keyChord]string, error) {
+	var commands []string
+	for command := range defaultKeyBindings {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+
+	for command := range keys {
+		if _, ok := defaultKeyBindings[command]; !ok {
+			return nil, fmt.Errorf("key binding for unknown command %s (commands: %s)", command, strings.Join(commands, ", "))
+		}
+	}
+
+	bindings := make(map[keyChord]string)
+	for _, command := range commands {
+		spec, ok := keys[command]
+		if !ok {
+			spec = defaultKeyBindings[command]
+		}
+		for _, field := range strings.Fields(spec) {
+			chord, err := parseKeyChord(field)
+			if err != nil {
+				return nil, fmt.Errorf("key binding for %s: %v", command, err)
+			}
+			if other, taken := bindings[chord]; taken {
+				return nil, fmt.Errorf("%s is bound to both %s and %s", field, other, command)
+			}
+			bindings[chord] = command
+		}
+	}
+	return bindings, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+func TestParseKeyChord(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    keyChord
+		wantErr bool
+	}{
+		{"r", keyChord{key: tcell.KeyRune, r: 'r'}, false},
+		{"R", keyChord{key: tcell.KeyRune, r: 'R'}, false},
+		{"Shift+r", keyChord{key: tcell.KeyRune, r: 'R'}, false},
+		{"Ctrl+L", keyChord{key: tcell.KeyRune, r: 'l', mods: tcell.ModCtrl}, false},
+		{"ctrl+alt+x", keyChord{key: tcell.KeyRune, r: 'x', mods: tcell.ModCtrl | tcell.ModAlt}, false},
+		{"Ctrl++", keyChord{key: tcell.KeyRune, r: '+', mods: tcell.ModCtrl}, false},
+		{"+", keyChord{key: tcell.KeyRune, r: '+'}, false},
+		{"F5", keyChord{key: tcell.KeyF5}, false},
+		{"Alt+Home", keyChord{key: tcell.KeyHome, mods: tcell.ModAlt}, false},
+		{"Shift+Backspace", keyChord{key: tcell.KeyBackspace2, mods: tcell.ModShift}, false},
+		{"escape", keyChord{key: tcell.KeyEscape}, false},
+		{"", keyChord{}, true},
+		{"Hyper+x", keyChord{}, true},
+		{"Ctrl+Nope", keyChord{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseKeyChord(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseKeyChord(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseKeyChord(%q) = %+v, want %+v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChordFromEventMatchesParsed(t *testing.T) {
+	tests := []struct {
+		spec string
+		ev   *tcell.EventKey
+	}{
+		{"Ctrl+Q", tcell.NewEventKey(tcell.KeyCtrlQ, 0, tcell.ModCtrl)},
+		{"Ctrl+L", tcell.NewEventKey(tcell.KeyRune, 'l', tcell.ModCtrl)},
+		{"R", tcell.NewEventKey(tcell.KeyRune, 'R', tcell.ModShift)},
+		{"Backspace", tcell.NewEventKey(tcell.KeyBackspace, 0, tcell.ModNone)},
+		{"Shift+Tab", tcell.NewEventKey(tcell.KeyBacktab, 0, tcell.ModNone)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			want, err := parseKeyChord(tt.spec)
+			if err != nil {
+				t.Fatalf("parseKeyChord(%q): %v", tt.spec, err)
+			}
+			if got := chordFromEvent(tt.ev); got != want {
+				t.Errorf("chordFromEvent = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestBindKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    map[string]string
+		chord   string // Looked up in the result
+		want    string // Command bound to chord, "" for none
+		wantErr bool
+	}{
+		{"defaults", nil, "Ctrl+Q", "quit", false},
+		{"escape is not quit", nil, "Escape", "", false},
+		{"override adds keys", map[string]string{"reload": "r F5"}, "F5", "reload", false},
+		{"override replaces keys", map[string]string{"reload": "F5"}, "r", "", false},
+		{"unknown command", map[string]string{"explode": "e"}, "", "", true},
+		{"bad key", map[string]string{"reload": "Ctrl+Nope"}, "", "", true},
+		{"key bound twice", map[string]string{"reload": "u"}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bindings, err := bindKeys(tt.keys)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("bindKeys error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			chord, err := parseKeyChord(tt.chord)
+			if err != nil {
+				t.Fatalf("parseKeyChord(%q): %v", tt.chord, err)
+			}
+			if got := bindings[chord]; got != tt.want {
+				t.Errorf("%s is bound to %q, want %q", tt.chord, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,14 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 type Config struct {
 	Debug           bool
-	ServerAddr      string
+	ServerAddr      string // As given; see ServerTarget
+	UseTCP          bool
+	ServerTarget    string // gRPC target for ServerAddr and UseTCP, e.g. unix:///tmp/termium.sock
 	SplashPath      string
 	LogFile         string
 	UseTCell        bool
@@ -17,6 +27,157 @@ type Config struct {
 	TraceProfile    string
 	ShowTimings     bool
 	Palette         string
+	Renderer        string
+	URL             string // Opened at startup, defaults to the home page
+	HomePage        string
+	LogPanelHeight  int
+	FPS             int
+	FrameFormat     string // One of frameFormats
+	Screencast      bool   // Frames from Chrome's screencast instead of screenshots
+	ConfigPath      string
+	Profile         string
+	Keys            map[string]string   // Key bindings from the config file, by command
+	KeyBindings     map[keyChord]string // Command bound to each key, defaults included
+}
+
+const (
+	DEFAULT_SOCKET_PATH  = "/tmp/termium.sock"
+	DEFAULT_TCP_PORT     = "50051"
+	DEFAULT_TCP_ADDRESS  = "localhost:" + DEFAULT_TCP_PORT
+	DEFAULT_HOME_PAGE    = "https://www.google.com"
+	DEFAULT_FPS          = 10
+	DEFAULT_FRAME_FORMAT = "jpeg"
+	MAX_FPS              = 60
+	MIN_LOG_PANEL_HEIGHT = 3 // Borders plus one line of messages
+)
+
+// fileSettings are the settings that can also come from the config file and the environment.
+// Empty fields are not set.
+type fileSettings struct {
+	Server         string            `json:"server"`
+	Palette        string            `json:"palette"`
+	Renderer       string            `json:"renderer"`
+	Home           string            `json:"home"`
+	LogPanelHeight int               `json:"log_panel_height"`
+	FPS            int               `json:"fps"`
+	FrameFormat    string            `json:"frame_format"`
+	Keys           map[string]string `json:"keys"`
+}
+
+// configFile is the layout of config.json: settings for every profile, plus named profiles that
+// override them
+type configFile struct {
+	fileSettings
+	Profile  string                  `json:"profile"` // Used when --profile is not given
+	Profiles map[string]fileSettings `json:"profiles"`
+}
+
+// defaultConfigPath returns $XDG_CONFIG_HOME/termium/config.json, with ~/.config when XDG_CONFIG_HOME is unset
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "termium", "config.json")
+}
+
+// loadConfigFile reads the config file and returns its settings with the profile applied.
+// A missing file is only an error when its path was given explicitly.
+func loadConfigFile(path string, explicit bool, profile string) (fileSettings, error) {
+	var file configFile
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		if profile != "" {
+			return fileSettings{}, fmt.Errorf("profile %s requested but there is no config file at %s", profile, path)
+		}
+		return fileSettings{}, nil
+	}
+	if err != nil {
+		return fileSettings{}, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return fileSettings{}, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	settings := file.fileSettings
+	if profile == "" {
+		profile = file.Profile
+	}
+	if profile != "" {
+		override, ok := file.Profiles[profile]
+		if !ok {
+			var names []string
+			for name := range file.Profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fileSettings{}, fmt.Errorf("unknown profile %s in %s (available: %s)", profile, path, strings.Join(names, ", "))
+		}
+		settings.merge(override)
+	}
+	return settings, nil
+}
+
+// envSettings reads the TERMIUM_* environment variables
+func envSettings() (fileSettings, error) {
+	settings := fileSettings{
+		Server:      os.Getenv("TERMIUM_SERVER"),
+		Palette:     os.Getenv("TERMIUM_PALETTE"),
+		Renderer:    os.Getenv("TERMIUM_RENDERER"),
+		Home:        os.Getenv("TERMIUM_HOME"),
+		FrameFormat: os.Getenv("TERMIUM_FRAME_FORMAT"),
+	}
+	for name, value := range map[string]*int{
+		"TERMIUM_LOG_PANEL_HEIGHT": &settings.LogPanelHeight,
+		"TERMIUM_FPS":              &settings.FPS,
+	} {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fileSettings{}, fmt.Errorf("%s must be a number, got %q", name, v)
+			}
+			*value = n
+		}
+	}
+	return settings, nil
+}
+
+// merge copies the settings that are set in other over the ones in fs
+func (fs *fileSettings) merge(other fileSettings) {
+	if other.Server != "" {
+		fs.Server = other.Server
+	}
+	if other.Palette != "" {
+		fs.Palette = other.Palette
+	}
+	if other.Renderer != "" {
+		fs.Renderer = other.Renderer
+	}
+	if other.Home != "" {
+		fs.Home = other.Home
+	}
+	if other.LogPanelHeight != 0 {
+		fs.LogPanelHeight = other.LogPanelHeight
+	}
+	if other.FPS != 0 {
+		fs.FPS = other.FPS
+	}
+	if other.FrameFormat != "" {
+		fs.FrameFormat = other.FrameFormat
+	}
+	for command, keys := range other.Keys {
+		if fs.Keys == nil {
+			fs.Keys = make(map[string]string)
+		}
+		fs.Keys[command] = keys
+	}
 }
 
 func parseFlags() (*Config, error) {
@@ -24,26 +185,37 @@ func parseFlags() (*Config, error) {
 
 	// Define flags
 	flag.BoolVar(&cfg.Debug, "debug", false, "Enable debug output")
-	flag.StringVar(&cfg.ServerAddr, "tcp", "", "Use TCP connection (default: Unix socket at /tmp/termium.sock, with --tcp defaults to localhost:50051)")
+	flag.StringVar(&cfg.ServerAddr, "server", "", "Server address: unix:///path/to/socket, tcp://host:port or host:port (default: unix://"+DEFAULT_SOCKET_PATH+")")
+	flag.BoolVar(&cfg.UseTCP, "tcp", false, "Connect over TCP, to "+DEFAULT_TCP_ADDRESS+" unless --server gives another address")
 	flag.StringVar(&cfg.SplashPath, "splash", "", "Path to custom splash screen image or NONE to skip splash screen")
 	flag.StringVar(&cfg.LogFile, "logfile", "", "Path to log file (optional, if not specified logs only go to console)")
 	flag.BoolVar(&cfg.UseTCell, "tcell", false, "Use tcell renderer instead of sixel graphics")
+	flag.StringVar(&cfg.Renderer, "renderer", "auto", "Renderer: auto, sixel, kitty, iterm2, tcell")
 	flag.BoolVar(&cfg.SaveScreenshots, "save-screenshots", false, "Save debug screenshots to disk (impacts performance)")
 	flag.StringVar(&cfg.CPUProfile, "cpuprofile", "", "Write CPU profile to file")
 	flag.StringVar(&cfg.TraceProfile, "trace", "", "Write execution trace to file")
 	flag.BoolVar(&cfg.ShowTimings, "timings", false, "Show timing measurements for each frame")
 	flag.StringVar(&cfg.Palette, "palette", "adaptive", "Color palette: adaptive, websafe, plan9")
 	flag.StringVar(&cfg.Palette, "p", "adaptive", "Color palette: adaptive, websafe, plan9 (short form)")
+	flag.StringVar(&cfg.URL, "url", "", "URL to open at startup instead of the home page (about:blank for a blank page)")
+	flag.StringVar(&cfg.HomePage, "home", DEFAULT_HOME_PAGE, "Home page, opened at startup and by the go home key")
+	flag.IntVar(&cfg.LogPanelHeight, "log-height", LOG_PANEL_HEIGHT, "Height of the log panel in lines, borders included")
+	flag.IntVar(&cfg.FPS, "fps", DEFAULT_FPS, "Highest frames per second to request from the server; fewer while the terminal cannot keep up")
+	flag.StringVar(&cfg.FrameFormat, "frame-format", DEFAULT_FRAME_FORMAT, "Frame format to request from the server: jpeg, png, rgba, zstd, lz4 (raw RGBA, plain or compressed, suits a local server)")
+	flag.BoolVar(&cfg.Screencast, "screencast", false, "Have the server send frames as Chrome repaints the page instead of taking screenshots at --fps")
+	flag.StringVar(&cfg.ConfigPath, "config", "", "Path to the config file (default: $XDG_CONFIG_HOME/termium/config.json)")
+	flag.StringVar(&cfg.Profile, "profile", "", "Config file profile to use")
 
 	// Handle both --flag and -flag formats
 	flag.BoolVar(&cfg.Debug, "d", false, "Enable debug output (shorthand)")
-	flag.StringVar(&cfg.ServerAddr, "s", "", "Server address (shorthand, only works with --tcp)")
+	flag.StringVar(&cfg.ServerAddr, "s", "", "Server address (shorthand)")
 	flag.StringVar(&cfg.LogFile, "l", "", "Path to log file (shorthand)")
 	flag.BoolVar(&cfg.UseTCell, "t", false, "Use tcell renderer (shorthand)")
+	flag.StringVar(&cfg.URL, "u", "", "URL to open at startup (shorthand)")
 
 	// Custom usage message
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage of %s: [flags] [url]\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\nFlags:\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nDebug Levels:\n")
@@ -54,13 +226,66 @@ func parseFlags() (*Config, error) {
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
 		fmt.Fprintf(os.Stderr, "  %s --debug --logfile /var/log/termium.log\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -d -l /var/log/termium.log -s remote:50051\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --tcp\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -s unix:///run/user/1000/termium.sock\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s https://example.com\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --splash NONE --url about:blank\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --profile laptop\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\nSettings are read from the config file, then TERMIUM_* environment variables, then flags;\n")
+		fmt.Fprintf(os.Stderr, "later ones win. See the README for the config file format.\n")
 	}
 
 	flag.Parse()
 
-	// Validate server address format
-	if cfg.ServerAddr != "" {
-		// TODO: Add validation for ip:port format
+	if err := applySettings(cfg); err != nil {
+		return nil, err
+	}
+
+	// The URL can also be given as the only positional argument
+	switch {
+	case flag.NArg() > 1:
+		return nil, fmt.Errorf("expected at most one URL, got %d arguments", flag.NArg())
+	case flag.NArg() == 1 && cfg.URL != "":
+		return nil, fmt.Errorf("URL given both with --url and as an argument")
+	case flag.NArg() == 1:
+		cfg.URL = flag.Arg(0)
+	}
+	if cfg.URL == "" {
+		cfg.URL = cfg.HomePage
+	}
+
+	var err error
+	if cfg.ServerTarget, err = serverTarget(cfg.ServerAddr, cfg.UseTCP); err != nil {
+		return nil, err
+	}
+
+	// --tcell is kept as a shorthand for --renderer tcell
+	if cfg.UseTCell {
+		cfg.Renderer = "tcell"
+	}
+	switch cfg.Renderer {
+	case "auto", "sixel", "kitty", "iterm2", "tcell":
+	default:
+		return nil, fmt.Errorf("unknown renderer: %s (expected auto, sixel, kitty, iterm2 or tcell)", cfg.Renderer)
+	}
+	switch cfg.Palette {
+	case "adaptive", "websafe", "plan9":
+	default:
+		return nil, fmt.Errorf("unknown palette: %s (expected adaptive, websafe or plan9)", cfg.Palette)
+	}
+
+	if cfg.FPS < 1 || cfg.FPS > MAX_FPS {
+		return nil, fmt.Errorf("fps must be between 1 and %d, got %d", MAX_FPS, cfg.FPS)
+	}
+	if _, ok := frameFormats[cfg.FrameFormat]; !ok {
+		return nil, fmt.Errorf("unknown frame format: %s (expected jpeg, png, rgba, zstd or lz4)", cfg.FrameFormat)
+	}
+	if cfg.LogPanelHeight < MIN_LOG_PANEL_HEIGHT {
+		return nil, fmt.Errorf("log panel height must be at least %d, got %d", MIN_LOG_PANEL_HEIGHT, cfg.LogPanelHeight)
+	}
+
+	if cfg.KeyBindings, err = bindKeys(cfg.Keys); err != nil {
+		return nil, err
 	}
 
 	// Check if splash image exists (only if specified and not NONE)
@@ -72,3 +297,107 @@ func parseFlags() (*Config, error) {
 
 	return cfg, nil
 }
+
+// serverTarget turns the server address into a gRPC target. Accepted are unix:///path/to/socket,
+// tcp://host:port and host:port. Without an address it is the default Unix socket, or
+// DEFAULT_TCP_ADDRESS with --tcp.
+func serverTarget(addr string, useTCP bool) (string, error) {
+	switch {
+	case addr == "" && useTCP:
+		return DEFAULT_TCP_ADDRESS, nil
+	case addr == "":
+		return "unix://" + DEFAULT_SOCKET_PATH, nil
+	case strings.HasPrefix(addr, "unix:"):
+		if useTCP {
+			return "", fmt.Errorf("--tcp conflicts with the Unix socket address %s; drop one of them", addr)
+		}
+		socket := strings.TrimPrefix(strings.TrimPrefix(addr, "unix:"), "//")
+		if !path.IsAbs(socket) {
+			return "", fmt.Errorf("invalid server address %s: the socket path must be absolute, e.g. unix://%s", addr, DEFAULT_SOCKET_PATH)
+		}
+		return "unix://" + socket, nil
+	case strings.HasPrefix(addr, "/"):
+		return "", fmt.Errorf("invalid server address %s: write Unix sockets as unix://%s", addr, addr)
+	}
+
+	hostPort := strings.TrimPrefix(addr, "tcp://")
+	if i := strings.Index(hostPort, "://"); i >= 0 {
+		return "", fmt.Errorf("unsupported scheme %s:// in server address %s (expected unix:// or tcp://)", hostPort[:i], addr)
+	}
+	if !strings.Contains(hostPort, ":") {
+		return "", fmt.Errorf("server address %s has no port; did you mean %s?", addr, net.JoinHostPort(hostPort, DEFAULT_TCP_PORT))
+	}
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return "", fmt.Errorf("invalid server address %s: expected host:port, e.g. %s", addr, DEFAULT_TCP_ADDRESS)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q in server address %s: expected a number from 1 to 65535", port, addr)
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// applySettings fills in the settings not given as flags from the environment and the config file
+func applySettings(cfg *Config) error {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	flagSet := func(names ...string) bool {
+		for _, name := range names {
+			if set[name] {
+				return true
+			}
+		}
+		return false
+	}
+
+	path, explicit := cfg.ConfigPath, cfg.ConfigPath != ""
+	if !explicit {
+		path, explicit = os.Getenv("TERMIUM_CONFIG"), os.Getenv("TERMIUM_CONFIG") != ""
+	}
+	if !explicit {
+		path = defaultConfigPath()
+	}
+	if cfg.Profile == "" {
+		cfg.Profile = os.Getenv("TERMIUM_PROFILE")
+	}
+
+	settings := fileSettings{}
+	if path != "" {
+		var err error
+		if settings, err = loadConfigFile(path, explicit, cfg.Profile); err != nil {
+			return err
+		}
+	}
+	env, err := envSettings()
+	if err != nil {
+		return err
+	}
+	settings.merge(env)
+
+	if settings.Server != "" && !flagSet("s", "server") {
+		cfg.ServerAddr = settings.Server
+	}
+	if settings.Palette != "" && !flagSet("palette", "p") {
+		cfg.Palette = settings.Palette
+	}
+	if settings.Renderer != "" && !flagSet("renderer", "tcell", "t") {
+		cfg.Renderer = settings.Renderer
+	}
+	if settings.Home != "" && !flagSet("home") {
+		cfg.HomePage = settings.Home
+	}
+	if settings.LogPanelHeight != 0 && !flagSet("log-height") {
+		cfg.LogPanelHeight = settings.LogPanelHeight
+	}
+	if settings.FPS != 0 && !flagSet("fps") {
+		cfg.FPS = settings.FPS
+	}
+	if settings.FrameFormat != "" && !flagSet("frame-format") {
+		cfg.FrameFormat = settings.FrameFormat
+	}
+	cfg.Keys = settings.Keys
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestServerTarget(t *testing.T) {
+	tests := []struct {
+		addr    string
+		useTCP  bool
+		want    string
+		wantErr bool
+	}{
+		{"", false, "unix://" + DEFAULT_SOCKET_PATH, false},
+		{"", true, DEFAULT_TCP_ADDRESS, false},
+		{"unix:///run/termium.sock", false, "unix:///run/termium.sock", false},
+		{"unix:/run/termium.sock", false, "unix:///run/termium.sock", false},
+		{"unix://run/termium.sock", false, "", true},
+		{"unix:///run/termium.sock", true, "", true},
+		{"/run/termium.sock", false, "", true},
+		{"example.com:9000", false, "example.com:9000", false},
+		{"tcp://example.com:9000", false, "example.com:9000", false},
+		{"example.com:9000", true, "example.com:9000", false},
+		{":9000", false, "localhost:9000", false},
+		{"[::1]:9000", false, "[::1]:9000", false},
+		{"http://example.com:9000", false, "", true},
+		{"example.com", false, "", true},
+		{"example.com:http", false, "", true},
+		{"example.com:70000", false, "", true},
+		{"::1:9000", false, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			got, err := serverTarget(tt.addr, tt.useTCP)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("serverTarget(%q, %v) error = %v, want error %v", tt.addr, tt.useTCP, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("serverTarget(%q, %v) = %q, want %q", tt.addr, tt.useTCP, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	pb "termium/client/pb"
+)
+
+const (
+	RECONNECT_INITIAL_BACKOFF = 500 * time.Millisecond
+	RECONNECT_MAX_BACKOFF     = 30 * time.Second
+	RECONNECT_TIMEOUT         = 5 * time.Second  // For each attempt to reach the server
+	RECONNECT_STABLE_AFTER    = 30 * time.Second // Connected this long, the next outage starts over with a short backoff
+)
+
+// Whether the server is reachable. Streams wait for it to come back instead of giving up.
+var connection struct {
+	sync.Mutex
+	up bool
+	// Counts reconnects, so a stream that fails late with an error from before a reconnect
+	// doesn't start another one
+	generation int
+	// Closed and replaced whenever up changes
+	changed chan struct{}
+	// Shown in the banner while the connection is down
+	status string
+}
+
+// Signals the supervisor that the connection was lost
+var connectionLostSignal = make(chan error, 1)
+
+func init() {
+	connection.up = true
+	connection.changed = make(chan struct{})
+}
+
+// connectionState returns the generation of the connection, for connectionLost, and whether it is up
+func connectionState() (int, bool) {
+	connection.Lock()
+	defer connection.Unlock()
+	return connection.generation, connection.up
+}
+
+// connectionWatch is connectionState plus a channel closed on the next change of it
+func connectionWatch() (int, bool, <-chan struct{}) {
+	connection.Lock()
+	defer connection.Unlock()
+	return connection.generation, connection.up, connection.changed
+}
+
+// waitConnected blocks until the connection is up and returns its generation.
+// It returns false when ctx is done first.
+func waitConnected(ctx context.Context) (int, bool) {
+	for {
+		generation, up, changed := connectionWatch()
+		if up {
+			return generation, true
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return 0, false
+		}
+	}
+}
+
+// setConnectionUp changes the connection state and wakes up everyone waiting for it
+func setConnectionUp(up bool, status string) {
+	connection.Lock()
+	defer connection.Unlock()
+	connection.status = status
+	if connection.up == up {
+		return
+	}
+	connection.up = up
+	if up {
+		connection.generation++
+	}
+	close(connection.changed)
+	connection.changed = make(chan struct{})
+}
+
+// connectionLost tells the supervisor the connection of the given generation failed.
+// Failures of an earlier generation are ignored, the supervisor already handled them.
+func connectionLost(generation int, err error) {
+	connection.Lock()
+	stale := !connection.up || generation != connection.generation
+	connection.Unlock()
+	if stale {
+		return
+	}
+
+	select {
+	case connectionLostSignal <- err:
+	default:
+	}
+}
+
+// connectionBanner returns the text of the disconnected banner, or "" while connected
+func connectionBanner() string {
+	connection.Lock()
+	defer connection.Unlock()
+	if connection.up {
+		return ""
+	}
+	return connection.status
+}
+
+// watchConnectionState reports the connection lost as soon as the channel fails, even when
+// no stream noticed yet
+func watchConnectionState() {
+	state := grpcConn.GetState()
+	for grpcConn.WaitForStateChange(context.Background(), state) {
+		state = grpcConn.GetState()
+		Debug(fmt.Sprintf("gRPC connection state: %v", state), DEBUG)
+		if state == connectivity.TransientFailure {
+			generation, _ := connectionState()
+			connectionLost(generation, fmt.Errorf("connection state %v", state))
+		}
+	}
+}
+
+// superviseConnection reconnects with exponential backoff whenever the connection is lost,
+// showing a banner until the session is restored
+func superviseConnection(s tcell.Screen) {
+	backoff := RECONNECT_INITIAL_BACKOFF
+	var connectedAt time.Time
+
+	for err := range connectionLostSignal {
+		Debug(fmt.Sprintf("Connection to server lost: %v", err), ERROR)
+		logBuffer.Write([]byte(fmt.Sprintf("Disconnected from server: %v", err)))
+		if time.Since(connectedAt) > RECONNECT_STABLE_AFTER {
+			backoff = RECONNECT_INITIAL_BACKOFF
+		}
+
+		for attempt := 1; ; attempt++ {
+			setConnectionUp(false, fmt.Sprintf(" Disconnected from server - reconnecting in %v (attempt %d) ",
+				backoff.Round(100*time.Millisecond), attempt))
+			s.PostEvent(tcell.NewEventInterrupt(redrawStatusLine))
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > RECONNECT_MAX_BACKOFF {
+				backoff = RECONNECT_MAX_BACKOFF
+			}
+
+			setConnectionUp(false, fmt.Sprintf(" Disconnected from server - reconnecting (attempt %d) ", attempt))
+			s.PostEvent(tcell.NewEventInterrupt(redrawStatusLine))
+			grpcConn.Connect()
+			if err := restoreSession(); err != nil {
+				Debug(fmt.Sprintf("Reconnect attempt %d failed: %v", attempt, err), WARN)
+				continue
+			}
+			break
+		}
+
+		connectedAt = time.Now()
+		setConnectionUp(true, "")
+		Debug("Reconnected to server", INFO)
+		logBuffer.Write([]byte("Reconnected to server"))
+		s.PostEvent(tcell.NewEventInterrupt(redrawTabStrip))
+		displayBottomPanel(s)
+	}
+}
+
+// restoreSession brings the server in line with the client once it is reachable again.
+// A restarted server has lost its tabs; they are reopened at the URLs they last showed.
+func restoreSession() error {
+	// One deadline for the whole attempt, shorter than the ones of the single RPCs
+	ctx, cancel := context.WithTimeout(context.Background(), RECONNECT_TIMEOUT)
+	defer cancel()
+
+	// Waits for the channel to connect instead of failing right away
+	list, err := grpcClient.ListTabs(ctx, &pb.Empty{}, grpc.WaitForReady(true))
+	if err != nil {
+		return fmt.Errorf("failed to reach server: %v", err)
+	}
+
+	if err := updateViewportSize(); err != nil {
+		return err
+	}
+
+	tabState.Lock()
+	known := append([]*pb.Tab(nil), tabState.tabs...)
+	activeID := tabState.activeID
+	tabState.Unlock()
+
+	// Same server, a server that kept its tabs, or tabs reopened by an earlier attempt that failed
+	// halfway: carry on with the server's tabs, only the streams need restarting
+	if len(list.Tabs) > 0 {
+		return refreshTabs()
+	}
+
+	// Nothing known yet if the server was down from the start; open the start page then
+	if len(known) == 0 {
+		known = []*pb.Tab{{Url: cfg.URL}}
+	}
+	Debug(fmt.Sprintf("Server lost the tabs, reopening %d", len(known)), INFO)
+
+	pageStatus.Lock()
+	pageStatus.tabs = nil // Keyed by the old tab IDs
+	pageStatus.Unlock()
+
+	newActiveID := ""
+	for _, old := range known {
+		tab, err := grpcClient.OpenTab(ctx, &pb.Empty{})
+		if err != nil {
+			return fmt.Errorf("failed to reopen tab: %v", err)
+		}
+		if old.TabId == activeID || newActiveID == "" {
+			newActiveID = tab.TabId
+		}
+		if old.Url != "" {
+			// Loading can take longer than a reconnect attempt, so it is not waited for
+			go restoreURL(tab.TabId, old.Url)
+		}
+	}
+	if _, err := grpcClient.SwitchTab(ctx, &pb.TabRequest{TabId: newActiveID}); err != nil {
+		return fmt.Errorf("failed to switch tab: %v", err)
+	}
+	return refreshTabs()
+}
+
+// restoreURL opens url in a reopened tab
+func restoreURL(tabID, url string) {
+	if _, err := grpcClient.NavigateToUrl(context.Background(), &pb.Url{Url: normalizeURL(url), TabId: tabID}); err != nil {
+		Debug(fmt.Sprintf("Failed to restore %s: %v", url, err), ERROR)
+		logBuffer.Write([]byte(fmt.Sprintf("Failed to restore %s: %v", url, err)))
+	}
+}
@@ -3,14 +3,23 @@ package main
 import (
 	"sync/atomic"
 	"time"
+
+	pb "termium/client/pb"
 )
 
 // Frame represents a single screenshot frame
 type Frame struct {
 	Data      []byte
+	Format    pb.FrameFormat
 	Width     int
 	Height    int
+	Stride    int // Bytes per row of the raw formats
 	Timestamp time.Time
+	InputSeq  uint64 // Last input message the server handled before taking it
+	Seq       uint64 // Counts frames from 1 per stream, 0 from servers without delta frames
+	Keyframe  bool   // Data holds the whole image, rather than Rects the parts that changed
+	Rects     []*pb.DirtyRect
+	Metadata  *pb.FrameMetadata // Screencast frames only
 }
 
 // FrameBuffer implements triple buffering for smooth frame updates
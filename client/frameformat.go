@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	pb "termium/client/pb"
+)
+
+// Frame formats by the name used for --frame-format
+var frameFormats = map[string]pb.FrameFormat{
+	"jpeg": pb.FrameFormat_FRAME_FORMAT_JPEG,
+	"png":  pb.FrameFormat_FRAME_FORMAT_PNG,
+	"rgba": pb.FrameFormat_FRAME_FORMAT_RGBA,
+	"zstd": pb.FrameFormat_FRAME_FORMAT_RGBA_ZSTD,
+	"lz4":  pb.FrameFormat_FRAME_FORMAT_RGBA_LZ4,
+}
+
+// File name extension of each format, for --save-screenshots
+var frameFormatExtensions = map[pb.FrameFormat]string{
+	pb.FrameFormat_FRAME_FORMAT_JPEG:      "jpg",
+	pb.FrameFormat_FRAME_FORMAT_PNG:       "png",
+	pb.FrameFormat_FRAME_FORMAT_RGBA:      "rgba",
+	pb.FrameFormat_FRAME_FORMAT_RGBA_ZSTD: "rgba.zst",
+	pb.FrameFormat_FRAME_FORMAT_RGBA_LZ4:  "rgba.lz4",
+}
+
+// Set up once, a zstd decoder allocates a lot when it is created
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+
+// decodeFrame returns the pixels of a frame. Raw frames are used in place, without a copy.
+func decodeFrame(frame *Frame) (*image.RGBA, error) {
+	switch frame.Format {
+	case pb.FrameFormat_FRAME_FORMAT_JPEG:
+		img, err := jpeg.Decode(bytes.NewReader(frame.Data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode JPEG frame: %v", err)
+		}
+		return toRGBA(img), nil
+
+	case pb.FrameFormat_FRAME_FORMAT_PNG:
+		img, err := png.Decode(bytes.NewReader(frame.Data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode PNG frame: %v", err)
+		}
+		return toRGBA(img), nil
+
+	case pb.FrameFormat_FRAME_FORMAT_RGBA, pb.FrameFormat_FRAME_FORMAT_RGBA_ZSTD, pb.FrameFormat_FRAME_FORMAT_RGBA_LZ4:
+		pix, err := rawPixels(frame.Format, frame.Data, frame.Stride*frame.Height)
+		if err != nil {
+			return nil, err
+		}
+		return rawFrame(frame, pix)
+	}
+	return nil, fmt.Errorf("unknown frame format %v", frame.Format)
+}
+
+// rawPixels decompresses data in one of the raw formats; size is the expected number of bytes
+func rawPixels(format pb.FrameFormat, data []byte, size int) ([]byte, error) {
+	switch format {
+	case pb.FrameFormat_FRAME_FORMAT_RGBA:
+		return data, nil
+
+	case pb.FrameFormat_FRAME_FORMAT_RGBA_ZSTD:
+		pix, err := zstdDecoder.DecodeAll(data, make([]byte, 0, size))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress zstd frame: %v", err)
+		}
+		return pix, nil
+
+	case pb.FrameFormat_FRAME_FORMAT_RGBA_LZ4:
+		pix := make([]byte, size)
+		if _, err := io.ReadFull(lz4.NewReader(bytes.NewReader(data)), pix); err != nil {
+			return nil, fmt.Errorf("failed to decompress lz4 frame: %v", err)
+		}
+		return pix, nil
+	}
+	return nil, fmt.Errorf("frame format %v has no raw pixels", format)
+}
+
+// applyDelta patches the rects of a delta frame into img and returns where they went
+func applyDelta(img *image.RGBA, frame *Frame) ([]image.Rectangle, error) {
+	dirty := make([]image.Rectangle, 0, len(frame.Rects))
+	for _, rect := range frame.Rects {
+		r := image.Rect(int(rect.X), int(rect.Y), int(rect.X+rect.Width), int(rect.Y+rect.Height))
+		if r.Empty() || !r.In(img.Bounds()) {
+			return nil, fmt.Errorf("delta rect %v outside of the %v frame", r, img.Bounds())
+		}
+		rowLen := 4 * r.Dx()
+		pix, err := rawPixels(frame.Format, rect.Data, rowLen*r.Dy())
+		if err != nil {
+			return nil, err
+		}
+		if len(pix) < rowLen*r.Dy() {
+			return nil, fmt.Errorf("delta rect %v has %d bytes, expected %d", r, len(pix), rowLen*r.Dy())
+		}
+		for y := 0; y < r.Dy(); y++ {
+			start := img.PixOffset(r.Min.X, r.Min.Y+y)
+			copy(img.Pix[start:start+rowLen], pix[y*rowLen:(y+1)*rowLen])
+		}
+		dirty = append(dirty, r)
+	}
+	return dirty, nil
+}
+
+// rawFrame wraps raw RGBA pixels laid out as the frame describes
+func rawFrame(frame *Frame, pix []byte) (*image.RGBA, error) {
+	if frame.Width <= 0 || frame.Height <= 0 || frame.Stride < 4*frame.Width {
+		return nil, fmt.Errorf("invalid raw frame layout: %dx%d, stride %d", frame.Width, frame.Height, frame.Stride)
+	}
+	if len(pix) < frame.Stride*(frame.Height-1)+4*frame.Width {
+		return nil, fmt.Errorf("raw frame has %d bytes, too few for %dx%d with stride %d",
+			len(pix), frame.Width, frame.Height, frame.Stride)
+	}
+	return &image.RGBA{Pix: pix, Stride: frame.Stride, Rect: image.Rect(0, 0, frame.Width, frame.Height)}, nil
+}
+
+// toRGBA returns img as RGBA, converting it if the decoder produced another color model
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, img.Bounds(), img, image.Point{0, 0}, draw.Src)
+	return rgba
+}
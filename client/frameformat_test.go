@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"reflect"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	pb "termium/client/pb"
+)
+
+func TestRawFrame(t *testing.T) {
+	tests := []struct {
+		name                  string
+		width, height, stride int
+		bytes                 int
+		wantErr               bool
+	}{
+		{"tight", 4, 3, 16, 48, false},
+		{"padded rows", 4, 3, 20, 60, false},
+		{"padding after the last row not needed", 4, 3, 20, 56, false},
+		{"too few bytes", 4, 3, 16, 47, true},
+		{"stride shorter than a row", 4, 3, 12, 48, true},
+		{"no width", 0, 3, 16, 48, true},
+		{"no height", 4, 0, 16, 48, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := &Frame{Width: tt.width, Height: tt.height, Stride: tt.stride}
+			img, err := rawFrame(frame, make([]byte, tt.bytes))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("rawFrame error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (img.Bounds() != image.Rect(0, 0, tt.width, tt.height) || img.Stride != tt.stride) {
+				t.Errorf("rawFrame = %v with stride %d, want %dx%d with stride %d",
+					img.Bounds(), img.Stride, tt.width, tt.height, tt.stride)
+			}
+		})
+	}
+}
+
+func TestDecodeFrame(t *testing.T) {
+	const width, height = 4, 3
+	src := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range src.Pix {
+		src.Pix[i] = byte(i)
+	}
+
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, src); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	encoder, _ := zstd.NewWriter(nil)
+	zstdData := encoder.EncodeAll(src.Pix, nil)
+	var lz4Data bytes.Buffer
+	w := lz4.NewWriter(&lz4Data)
+	w.Write(src.Pix)
+	w.Close()
+
+	tests := []struct {
+		name    string
+		format  pb.FrameFormat
+		data    []byte
+		wantErr bool
+	}{
+		{"rgba", pb.FrameFormat_FRAME_FORMAT_RGBA, src.Pix, false},
+		{"zstd", pb.FrameFormat_FRAME_FORMAT_RGBA_ZSTD, zstdData, false},
+		{"lz4", pb.FrameFormat_FRAME_FORMAT_RGBA_LZ4, lz4Data.Bytes(), false},
+		{"png", pb.FrameFormat_FRAME_FORMAT_PNG, pngData.Bytes(), false},
+		{"truncated rgba", pb.FrameFormat_FRAME_FORMAT_RGBA, src.Pix[:len(src.Pix)-1], true},
+		{"corrupt zstd", pb.FrameFormat_FRAME_FORMAT_RGBA_ZSTD, []byte("not zstd"), true},
+		{"corrupt png", pb.FrameFormat_FRAME_FORMAT_PNG, []byte("not png"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := &Frame{Data: tt.data, Format: tt.format, Width: width, Height: height, Stride: width * 4}
+			img, err := decodeFrame(frame)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeFrame error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(img.Pix, src.Pix) {
+				t.Errorf("decodeFrame pixels = %v, want %v", img.Pix, src.Pix)
+			}
+		})
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	rect := func(x, y, w, h int) *pb.DirtyRect {
+		return &pb.DirtyRect{X: int32(x), Y: int32(y), Width: int32(w), Height: int32(h),
+			Data: bytes.Repeat([]byte{0xff}, w*h*4)}
+	}
+	tests := []struct {
+		name    string
+		rects   []*pb.DirtyRect
+		want    []image.Rectangle
+		wantErr bool
+	}{
+		{"no rects", nil, []image.Rectangle{}, false},
+		{"one rect", []*pb.DirtyRect{rect(1, 1, 2, 2)}, []image.Rectangle{image.Rect(1, 1, 3, 3)}, false},
+		{"two rects", []*pb.DirtyRect{rect(0, 0, 1, 1), rect(3, 2, 1, 1)},
+			[]image.Rectangle{image.Rect(0, 0, 1, 1), image.Rect(3, 2, 4, 3)}, false},
+		{"outside the frame", []*pb.DirtyRect{rect(3, 2, 2, 1)}, nil, true},
+		{"empty rect", []*pb.DirtyRect{rect(1, 1, 0, 1)}, nil, true},
+		{"too little data", []*pb.DirtyRect{{X: 0, Y: 0, Width: 2, Height: 2, Data: make([]byte, 15)}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewRGBA(image.Rect(0, 0, 4, 3))
+			dirty, err := applyDelta(img, &Frame{Format: pb.FrameFormat_FRAME_FORMAT_RGBA, Rects: tt.rects})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyDelta error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(dirty, tt.want) {
+				t.Errorf("applyDelta = %v, want %v", dirty, tt.want)
+			}
+			for y := 0; y < 3; y++ {
+				for x := 0; x < 4; x++ {
+					inside := false
+					for _, r := range tt.want {
+						inside = inside || image.Pt(x, y).In(r)
+					}
+					if got := img.RGBAAt(x, y).R == 0xff; got != inside {
+						t.Errorf("pixel (%d,%d) patched = %v, want %v", x, y, got, inside)
+					}
+				}
+			}
+		})
+	}
+}
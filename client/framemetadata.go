@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"sync"
+	"time"
+
+	pb "termium/client/pb"
+)
+
+// Where the page is in the frames shown, from the metadata the screencast sends with them
+var frameGeometry struct {
+	sync.Mutex
+	metadata *pb.FrameMetadata // Of the last screencast frame shown, nil before one was
+	shown    image.Point       // Size the frame is shown at, in terminal pixels
+}
+
+// Time from Chrome painting a screencast frame to showing it
+var paintLatency struct {
+	sync.Mutex
+	average time.Duration
+	samples int
+}
+
+// frameShownAt records the size the image buffer is shown at, for pagePoint
+func frameShownAt(size image.Point) {
+	frameGeometry.Lock()
+	defer frameGeometry.Unlock()
+	frameGeometry.shown = size
+}
+
+// frameMetadataShown is called once a frame was shown, with its metadata if it came from the
+// screencast. Screenshots carry none and keep the metadata of the last screencast frame.
+func frameMetadataShown(metadata *pb.FrameMetadata) {
+	if metadata == nil {
+		return
+	}
+	frameGeometry.Lock()
+	frameGeometry.metadata = metadata
+	frameGeometry.Unlock()
+
+	if metadata.Timestamp <= 0 {
+		return
+	}
+	// The server's clock, close enough to ours when it runs on the same machine
+	painted := time.Unix(0, int64(metadata.Timestamp*float64(time.Second)))
+	sample := time.Since(painted)
+	if sample < 0 {
+		return
+	}
+	paintLatency.Lock()
+	defer paintLatency.Unlock()
+	if paintLatency.samples == 0 {
+		paintLatency.average = sample
+	} else {
+		paintLatency.average += time.Duration(LATENCY_SMOOTHING * float64(sample-paintLatency.average))
+	}
+	paintLatency.samples++
+	Debug(fmt.Sprintf("Paint latency %v (average %v), scrolled to %.0f,%.0f", sample.Round(time.Millisecond),
+		paintLatency.average.Round(time.Millisecond), metadata.ScrollOffsetX, metadata.ScrollOffsetY), DEBUG)
+}
+
+// averagePaintLatency returns the smoothed paint latency, 0 without screencast frames
+func averagePaintLatency() time.Duration {
+	paintLatency.Lock()
+	defer paintLatency.Unlock()
+	return paintLatency.average
+}
+
+// pagePoint maps a pixel of the browser panel to the CSS pixel of the page under it. The screencast
+// tells the viewport's size, so frames scaled to fit the panel or taken at a higher device scale
+// still map right. Without it the page is taken to be shown at the size the viewport was set to.
+func pagePoint(px, py int) (int, int) {
+	frameGeometry.Lock()
+	defer frameGeometry.Unlock()
+	metadata := frameGeometry.metadata
+	if metadata == nil || metadata.DeviceWidth <= 0 || frameGeometry.shown.X <= 0 {
+		return px, py
+	}
+	zoom := metadata.DeviceWidth / float64(frameGeometry.shown.X)
+	x := int(float64(px) * zoom)
+	y := int(float64(py)*zoom - metadata.OffsetTop)
+	return max(0, min(x, int(metadata.DeviceWidth)-1)), max(0, min(y, int(metadata.DeviceHeight)-1))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"image"
+	"testing"
+
+	pb "termium/client/pb"
+)
+
+func TestPagePoint(t *testing.T) {
+	viewport := &pb.FrameMetadata{DeviceWidth: 800, DeviceHeight: 600}
+	tests := []struct {
+		name         string
+		metadata     *pb.FrameMetadata
+		shown        image.Point
+		px, py       int
+		wantX, wantY int
+	}{
+		{"no screencast frame yet", nil, image.Pt(400, 300), 100, 50, 100, 50},
+		{"nothing shown yet", viewport, image.Point{}, 100, 50, 100, 50},
+		{"shown at viewport size", viewport, image.Pt(800, 600), 100, 50, 100, 50},
+		{"scaled down to fit", viewport, image.Pt(400, 300), 100, 50, 200, 100},
+		{"top offset", &pb.FrameMetadata{DeviceWidth: 800, DeviceHeight: 600, OffsetTop: 20},
+			image.Pt(800, 620), 100, 50, 100, 30},
+		{"above the page", &pb.FrameMetadata{DeviceWidth: 800, DeviceHeight: 600, OffsetTop: 20},
+			image.Pt(800, 620), 100, 5, 100, 0},
+		{"past the edge", viewport, image.Pt(400, 300), 450, 350, 799, 599},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frameGeometry.Lock()
+			frameGeometry.metadata, frameGeometry.shown = tt.metadata, tt.shown
+			frameGeometry.Unlock()
+			t.Cleanup(func() { frameGeometry.metadata, frameGeometry.shown = nil, image.Point{} })
+
+			if x, y := pagePoint(tt.px, tt.py); x != tt.wantX || y != tt.wantY {
+				t.Errorf("pagePoint(%d, %d) = %d, %d, want %d, %d", tt.px, tt.py, x, y, tt.wantX, tt.wantY)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	pb "termium/client/pb"
+)
+
+const (
+	// Input events waiting to be sent. When the server falls behind the queue fills up and the
+	// event loop waits for it, rather than input piling up without bound.
+	INPUT_QUEUE_SIZE = 256
+	// How long the event loop waits for room in a full queue. A server that is stuck rather than
+	// slow loses input instead of freezing the UI.
+	INPUT_QUEUE_TIMEOUT = time.Second
+)
+
+// queuedInput is one input event, viewport change or session control message waiting to be sent.
+// Mouse moves carry no event: the sender picks up the latest position of their slot.
+type queuedInput struct {
+	event    *pb.InputEvent
+	viewport *pb.ViewportSize
+	control  *pb.SessionControl
+	move     *moveSlot
+}
+
+// Everything sent on the session shares one queue, so nothing overtakes what came before it:
+// a click made after a resize lands where it was aimed
+var inputQueue = make(chan queuedInput, INPUT_QUEUE_SIZE)
+
+// queueInput queues an event for the server
+func queueInput(event *pb.InputEvent) {
+	enqueueInput(queuedInput{event: event})
+}
+
+// enqueueInput adds to the input queue, returning false when the item was dropped because the
+// queue stayed full
+func enqueueInput(item queuedInput) bool {
+	// Input may change the page, idle frames would show it late
+	if item.control == nil {
+		frameActivity()
+	}
+	if item.move == nil {
+		closeMoveSlot()
+	}
+
+	select {
+	case inputQueue <- item:
+		return true
+	default:
+	}
+
+	select {
+	case inputQueue <- item:
+		return true
+	case <-time.After(INPUT_QUEUE_TIMEOUT):
+		Debug("Server is not keeping up with input, dropped an event", WARN)
+		return false
+	}
+}
+
+// typedKey reports whether a key event just types its character, so it can be sent as text
+func typedKey(key *pb.KeyEvent) bool {
+	if key == nil || key.Repeat || key.Modifiers&^uint32(pb.KeyModifier_KEY_MODIFIER_SHIFT) != 0 {
+		return false
+	}
+	r, size := utf8.DecodeRuneInString(key.Key)
+	return size == len(key.Key) && unicode.IsPrint(r)
+}
+
+// queueViewportSize queues the current viewport dimensions for the server, behind the input made
+// before the resize
+func queueViewportSize() {
+	Debug(fmt.Sprintf("Queueing viewport size %dx%d pixels", sDims.InnerWidthPx, sDims.InnerHeightPx), DEBUG)
+	enqueueInput(queuedInput{viewport: &pb.ViewportSize{
+		Width:  int32(sDims.InnerWidthPx),
+		Height: int32(sDims.InnerHeightPx),
+	}})
+}
+
+// inputMessages turns a queued item into the session messages to send. Moves resolve to the latest
+// position of their slot, and characters typed behind a typed character join it as one text event.
+func inputMessages(item queuedInput) []*pb.ClientMessage {
+	if item.viewport != nil {
+		return []*pb.ClientMessage{{Message: &pb.ClientMessage_Viewport{Viewport: item.viewport}}}
+	}
+	if item.control != nil {
+		return []*pb.ClientMessage{{Message: &pb.ClientMessage_Control{Control: item.control}}}
+	}
+
+	event := item.event
+	if item.move != nil {
+		if event = takePendingMove(item.move); event == nil {
+			return nil
+		}
+	}
+
+	var carried *queuedInput // Taken from the queue while batching, but not part of the batch
+	if key := event.GetKey(); typedKey(key) {
+		text, count := key.Key, 1
+	batch:
+		for {
+			select {
+			case next := <-inputQueue:
+				if k := next.event.GetKey(); typedKey(k) && k.TabId == key.TabId {
+					text += k.Key
+					count++
+					continue
+				}
+				carried = &next
+				break batch
+			default:
+				break batch
+			}
+		}
+		if count > 1 {
+			Debug(fmt.Sprintf("Batched %d typed characters", count), DEBUG)
+			event = &pb.InputEvent{Event: &pb.InputEvent_Text{Text: &pb.Text{Content: text, TabId: key.TabId}}}
+		}
+	}
+
+	messages := []*pb.ClientMessage{{Message: &pb.ClientMessage_Input{Input: event}}}
+	if carried != nil {
+		messages = append(messages, inputMessages(*carried)...)
+	}
+	return messages
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// iTerm2 inline images (OSC 1337) - https://iterm2.com/documentation-images.html
+// Also understood by WezTerm. The terminal decodes the image itself, so there is no palette quantization.
+
+// itermRenderer sends frames as OSC 1337 inline PNGs positioned inside the browser panel
+type itermRenderer struct {
+	// Reusable PNG encoder state - frames are large, so avoid reallocating buffers every time
+	encoder png.Encoder
+	pngBuf  bytes.Buffer
+	stats   RendererStats
+}
+
+func newITermRenderer() *itermRenderer {
+	return &itermRenderer{
+		encoder: png.Encoder{CompressionLevel: png.BestSpeed, BufferPool: &itermBufferPool{}},
+	}
+}
+
+func (r *itermRenderer) Name() string { return "iterm2" }
+
+func (r *itermRenderer) Init(s tcell.Screen) error { return nil }
+
+// The image becomes part of the cells it covers, so tcell clears it like text
+// and every frame is sent whole - no state to resize, invalidate or clean up
+func (r *itermRenderer) Resize(widthPx, heightPx int) {}
+
+func (r *itermRenderer) Invalidate() {}
+
+func (r *itermRenderer) Stats() RendererStats { return r.stats }
+
+func (r *itermRenderer) Close() {}
+
+// itermBufferPool keeps the PNG encoder's internal buffers alive between frames
+type itermBufferPool struct {
+	buf *png.EncoderBuffer
+}
+
+func (p *itermBufferPool) Get() *png.EncoderBuffer {
+	return p.buf
+}
+
+func (p *itermBufferPool) Put(buf *png.EncoderBuffer) {
+	p.buf = buf
+}
+
+// DrawFrame sends the frame with its size in cells so the terminal maps it onto exactly the browser panel.
+// The image is replaced whole, so what changed does not matter.
+func (r *itermRenderer) DrawFrame(s tcell.Screen, img *image.RGBA, dirty []image.Rectangle) error {
+	itermStart := time.Now()
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil
+	}
+
+	encodeStart := time.Now()
+	r.pngBuf.Reset()
+	if err := r.encoder.Encode(&r.pngBuf, img); err != nil {
+		Debug(fmt.Sprintf("PNG encoding error: %v", err), ERROR)
+		return fmt.Errorf("png encoding error: %v", err)
+	}
+	payload := base64.StdEncoding.EncodeToString(r.pngBuf.Bytes())
+
+	// Size the image in cells so the terminal maps it onto exactly the area we drew it for
+	widthCells := (width + charSize.Width - 1) / charSize.Width
+	heightCells := (height + charSize.Height - 1) / charSize.Height
+
+	buf := bufio.NewWriterSize(os.Stdout, 64*1024)
+	defer buf.Flush()
+
+	// Position cursor at the top-left of the usable area (after borders)
+	fmt.Fprintf(buf, "\033[%d;%dH", V_BORDER_WIDTH+1, H_BORDER_WIDTH+1)
+	buf.WriteString("\033[s")
+
+	fmt.Fprintf(buf, "\033]1337;File=inline=1;size=%d;width=%d;height=%d;preserveAspectRatio=1:",
+		r.pngBuf.Len(), widthCells, heightCells)
+	buf.WriteString(payload)
+	buf.WriteString("\a")
+
+	// Restore cursor position - the terminal moves it below the image otherwise
+	buf.WriteString("\033[u")
+
+	if cfg.ShowTimings {
+		fmt.Fprintf(os.Stderr, "  iTerm2 PNG encode time: %v (rendered size: %dx%d pixels, %d bytes)\n",
+			time.Since(encodeStart), width, height, r.pngBuf.Len())
+		os.Stderr.Sync() // Force flush stderr
+	}
+
+	r.stats.record(time.Since(itermStart), len(payload))
+	Debug(fmt.Sprintf("Displayed iTerm2 image at (%d,%d) with size %dx%d (%dx%d cells, took %v)",
+		H_BORDER_WIDTH, V_BORDER_WIDTH, width, height, widthCells, heightCells, time.Since(itermStart)), DEBUG)
+
+	return nil
+}
+
+// detectITermImages reports whether the terminal identifies itself as one that supports OSC 1337 images.
+// Neither iTerm2 nor WezTerm answers a query for this, so the environment is all we have.
+func detectITermImages() bool {
+	switch os.Getenv("TERM_PROGRAM") {
+	case "iTerm.app", "WezTerm":
+		return true
+	}
+	return os.Getenv("LC_TERMINAL") == "iTerm2"
+}
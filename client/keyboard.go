@@ -4,12 +4,23 @@ import (
 	"context"
 	"fmt"
 	"strings"
-	"time"
 
 	"github.com/gdamore/tcell/v2"
 	pb "termium/client/pb"
 )
 
+// BrowserMode decides what keys do
+type BrowserMode int
+
+const (
+	// ModeNormal: single keys are browser commands, keys without a command go to the page
+	ModeNormal BrowserMode = iota
+	// ModeInsert: every key except Escape goes to the page, for typing into forms
+	ModeInsert
+	// ModeURL: keys edit the URL prompt
+	ModeURL
+)
+
 // KeyboardHandler manages all keyboard input for the application
 type KeyboardHandler struct {
 	browserMode  BrowserMode
@@ -28,9 +39,11 @@ func NewKeyboardHandler(client pb.BrowserControlClient) *KeyboardHandler {
 
 // HandleKeyEvent processes keyboard events and returns true if should exit
 func (kh *KeyboardHandler) HandleKeyEvent(s tcell.Screen, ev *tcell.EventKey) bool {
-	// Handle URL input mode separately
-	if kh.browserMode == ModeURL {
+	switch kh.browserMode {
+	case ModeURL:
 		return kh.handleURLModeKey(s, ev)
+	case ModeInsert:
+		return kh.handleInsertModeKey(s, ev)
 	}
 
 	// Normal mode handling
@@ -50,17 +63,17 @@ func (kh *KeyboardHandler) handleURLModeKey(s tcell.Screen, ev *tcell.EventKey)
 	case tcell.KeyEnter:
 		// Submit URL and immediately return to normal mode
 		url := kh.urlBuffer
-		
+
 		// Immediately show status and return to normal mode
 		logBuffer.Write([]byte(fmt.Sprintf("Navigation request sent to: %s", url)))
 		displayBottomPanel(s)
-		
+
 		kh.browserMode = ModeNormal
 		kh.clearURLPrompt(s)
-		
+
 		// Navigate asynchronously in the background
 		go kh.navigateToURLAsync(url, s)
-		
+
 		Debug(fmt.Sprintf("URL submitted: %s", url), DEBUG)
 		return false
 
@@ -129,97 +142,135 @@ func (kh *KeyboardHandler) handleURLModeKey(s tcell.Screen, ev *tcell.EventKey)
 
 // handleNormalModeKey handles keyboard input in normal browsing mode
 func (kh *KeyboardHandler) handleNormalModeKey(s tcell.Screen, ev *tcell.EventKey) bool {
-	oldX, oldY := cursor.x, cursor.y
-
-	if ev.Modifiers()&tcell.ModCtrl != 0 {
-		// Ctrl+Key combinations
-		// Check both Rune and Key for Ctrl+L (tcell might report it differently)
-		if ev.Rune() == 'l' || ev.Rune() == 'L' || ev.Key() == tcell.KeyCtrlL {
-			// Ctrl+L - Show URL bar with current URL
-			Debug("Ctrl+L detected, entering URL mode", DEBUG)
-			kh.browserMode = ModeURL
-			kh.urlBuffer = kh.getCurrentURL()
-			kh.urlCursorPos = len(kh.urlBuffer)
-			kh.showURLPrompt(s)
-			return false
-		}
-
-		// Handle other Ctrl+Key combinations if needed
-		switch ev.Key() {
-		case tcell.KeyUp:
-			Debug("Ctrl+Up pressed", DEBUG)
-			return false
-		case tcell.KeyDown:
-			Debug("Ctrl+Down pressed", DEBUG)
-			return false
-		case tcell.KeyLeft:
-			Debug("Ctrl+Left pressed", DEBUG)
-			return false
-		case tcell.KeyRight:
-			Debug("Ctrl+Right pressed", DEBUG)
-			return false
-		default:
-			// Don't send Ctrl+key combinations to the server
-			Debug(fmt.Sprintf("Unhandled Ctrl+key: Key=%v, Rune=%c", ev.Key(), ev.Rune()), DEBUG)
-			return false
-		}
-	} else {
-		// Regular keys
-		switch ev.Key() {
-		case tcell.KeyEscape:
-			Debug("Exit key pressed", DEBUG)
-			// Clean shutdown will be handled by main loop
-			return true // Signal to exit
-
-		case tcell.KeyUp:
-			if cursor.y > V_BORDER_WIDTH {
-				cursor.y--
-			}
-
-		case tcell.KeyDown:
-			if cursor.y < sDims.Height-(V_BORDER_WIDTH+sDims.LogHeight) {
-				cursor.y++
-			}
-
-		case tcell.KeyLeft:
-			if cursor.x > H_BORDER_WIDTH {
-				cursor.x--
-			}
-
-		case tcell.KeyRight:
-			if cursor.x < sDims.Width-H_BORDER_WIDTH {
-				cursor.x++
-			}
-
-		case tcell.KeyEnter:
-			// Send Enter key to browser (for form submission, etc.)
-			Debug("Sending Enter key to browser", DEBUG)
-			go kh.sendSpecialKey("Enter")
-			
-		case tcell.KeyTab:
-			// Send Tab key to browser (for form navigation)
-			Debug("Sending Tab key to browser", DEBUG)
-			go kh.sendSpecialKey("Tab")
-			
-		case tcell.KeyBackspace, tcell.KeyBackspace2:
-			// Send Backspace to browser (for text input)
-			Debug("Sending Backspace key to browser", DEBUG)
-			go kh.sendSpecialKey("Backspace")
-
-		default:
-			// Send regular keyboard input to server
-			if ev.Rune() != 0 {
-				go kh.sendKeyboardInput(string(ev.Rune()))
-			}
-		}
+	if command, ok := cfg.KeyBindings[chordFromEvent(ev)]; ok {
+		Debug(fmt.Sprintf("Key=%v, Rune=%c runs %s", ev.Key(), ev.Rune(), command), DEBUG)
+		return normalModeCommands[command](kh, s)
 	}
 
-	if oldX != cursor.x || oldY != cursor.y {
-		redrawImageArea(s, oldX, oldY)
-		redrawImageArea(s, cursor.x, cursor.y)
+	// Everything else, shortcuts included, goes to the page
+	forwardKeyEvent(ev)
+	return false // Don't exit
+}
+
+// promptForURL is posted as an interrupt to open the URL prompt from outside the event loop, once a
+// new tab is open
+type urlPrompt struct{}
+
+var promptForURL = urlPrompt{}
+
+// Normal mode commands by the name key bindings use for them. They return true to exit.
+var normalModeCommands = map[string]func(kh *KeyboardHandler, s tcell.Screen) bool{
+	"url": func(kh *KeyboardHandler, s tcell.Screen) bool {
+		// Start from an empty prompt for a new address; edit-url edits the current one
+		Debug("Entering URL mode", DEBUG)
+		kh.enterURLMode(s, "")
+		return false
+	},
+	"edit-url": func(kh *KeyboardHandler, s tcell.Screen) bool {
+		kh.enterURLMode(s, activeTabURL())
+		return false
+	},
+	"back": func(kh *KeyboardHandler, s tcell.Screen) bool {
+		go kh.browserCommand(s, "Go back", func(ctx context.Context, tab string) (*pb.Message, error) {
+			return kh.grpcClient.GoBack(ctx, &pb.TabRequest{TabId: tab})
+		})
+		return false
+	},
+	"forward": func(kh *KeyboardHandler, s tcell.Screen) bool {
+		go kh.browserCommand(s, "Go forward", func(ctx context.Context, tab string) (*pb.Message, error) {
+			return kh.grpcClient.GoForward(ctx, &pb.TabRequest{TabId: tab})
+		})
+		return false
+	},
+	"home": func(kh *KeyboardHandler, s tcell.Screen) bool {
+		kh.goHome(s)
+		return false
+	},
+	"reload": func(kh *KeyboardHandler, s tcell.Screen) bool {
+		kh.reload(s, false)
+		return false
+	},
+	"hard-reload": func(kh *KeyboardHandler, s tcell.Screen) bool {
+		// Bypasses the cache, like Shift+F5
+		kh.reload(s, true)
+		return false
+	},
+	"stop": func(kh *KeyboardHandler, s tcell.Screen) bool {
+		go kh.browserCommand(s, "Stop loading", func(ctx context.Context, tab string) (*pb.Message, error) {
+			return kh.grpcClient.StopLoading(ctx, &pb.TabRequest{TabId: tab})
+		})
+		return false
+	},
+	"new-tab": func(kh *KeyboardHandler, s tcell.Screen) bool {
+		// New tab, then ask where to go like a desktop browser does
+		runTabCommand(s, openTab, func() {
+			s.PostEvent(tcell.NewEventInterrupt(promptForURL))
+		})
+		return false
+	},
+	"close-tab": func(kh *KeyboardHandler, s tcell.Screen) bool {
+		runTabCommand(s, closeTab, nil)
+		return false
+	},
+	"prev-tab": func(kh *KeyboardHandler, s tcell.Screen) bool {
+		runTabCommand(s, func(s tcell.Screen) error { return cycleTab(s, -1) }, nil)
+		return false
+	},
+	"next-tab": func(kh *KeyboardHandler, s tcell.Screen) bool {
+		runTabCommand(s, func(s tcell.Screen) error { return cycleTab(s, 1) }, nil)
+		return false
+	},
+	"insert": func(kh *KeyboardHandler, s tcell.Screen) bool {
+		kh.browserMode = ModeInsert
+		logBuffer.Write([]byte("-- INSERT -- keys go to the page, Escape to leave"))
+		displayBottomPanel(s)
+		return false
+	},
+	"next-renderer": func(kh *KeyboardHandler, s tcell.Screen) bool {
+		// Switch renderer without restarting the session
+		cycleRenderer(s)
+		return false
+	},
+	"quit": func(kh *KeyboardHandler, s tcell.Screen) bool {
+		Debug("Exit key pressed", DEBUG)
+		// Clean shutdown will be handled by main loop
+		return true
+	},
+}
+
+// handleInsertModeKey sends every key to the page until Escape
+func (kh *KeyboardHandler) handleInsertModeKey(s tcell.Screen, ev *tcell.EventKey) bool {
+	if ev.Key() == tcell.KeyEscape {
+		kh.browserMode = ModeNormal
+		logBuffer.Write([]byte("-- NORMAL --"))
+		displayBottomPanel(s)
+		return false
 	}
 
-	return false // Don't exit
+	forwardKeyEvent(ev)
+	return false
+}
+
+// goHome opens the configured home page in the active tab
+func (kh *KeyboardHandler) goHome(s tcell.Screen) {
+	logBuffer.Write([]byte(fmt.Sprintf("Going home to %s", cfg.HomePage)))
+	displayBottomPanel(s)
+	go kh.navigateToURLAsync(cfg.HomePage, s)
+}
+
+// reload reloads the active tab, from the network only when ignoreCache is set
+func (kh *KeyboardHandler) reload(s tcell.Screen, ignoreCache bool) {
+	go kh.browserCommand(s, "Reload", func(ctx context.Context, tab string) (*pb.Message, error) {
+		return kh.grpcClient.Reload(ctx, &pb.ReloadRequest{TabId: tab, IgnoreCache: ignoreCache})
+	})
+}
+
+// enterURLMode opens the URL prompt with url filled in
+func (kh *KeyboardHandler) enterURLMode(s tcell.Screen, url string) {
+	kh.browserMode = ModeURL
+	kh.urlBuffer = url
+	kh.urlCursorPos = len(kh.urlBuffer)
+	kh.showURLPrompt(s)
 }
 
 // GetBrowserMode returns the current browser mode
@@ -280,14 +331,13 @@ func (kh *KeyboardHandler) clearURLPrompt(s tcell.Screen) {
 
 // Browser interaction functions
 
-// getCurrentURL fetches the current URL from the browser
-func (kh *KeyboardHandler) getCurrentURL() string {
-	resp, err := kh.grpcClient.GetCurrentUrl(context.Background(), &pb.Empty{})
-	if err != nil {
-		Debug(fmt.Sprintf("Failed to get current URL: %v", err), ERROR)
-		return ""
+// normalizeURL adds https:// to addresses typed without a scheme.
+// about: URLs such as about:blank are left alone.
+func normalizeURL(url string) string {
+	if strings.HasPrefix(url, "about:") || strings.Contains(url, "://") {
+		return url
 	}
-	return resp.Url
+	return "https://" + url
 }
 
 // navigateToURLAsync sends the URL to the server asynchronously and updates status
@@ -298,18 +348,12 @@ func (kh *KeyboardHandler) navigateToURLAsync(url string, s tcell.Screen) {
 		return
 	}
 
-	// Add https:// if no protocol specified
-	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
-		url = "https://" + url
-	}
+	url = normalizeURL(url)
 
 	Debug(fmt.Sprintf("Navigating to URL: %s", url), INFO)
-	
-	// Set a reasonable timeout for the navigation request
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	
-	_, err := kh.grpcClient.NavigateToUrl(ctx, &pb.Url{Url: url})
+
+	// The RPC layer gives navigation time to load the page (rpcTimeouts)
+	_, err := kh.grpcClient.NavigateToUrl(context.Background(), &pb.Url{Url: url, TabId: activeTabID()})
 	if err != nil {
 		errorMsg := fmt.Sprintf("Navigation failed for '%s': %v", url, err)
 		Debug(errorMsg, ERROR)
@@ -323,23 +367,20 @@ func (kh *KeyboardHandler) navigateToURLAsync(url string, s tcell.Screen) {
 	displayBottomPanel(s)
 }
 
-// sendKeyboardInput sends keyboard input to the server
-func (kh *KeyboardHandler) sendKeyboardInput(text string) {
-	Debug(fmt.Sprintf("Sending keyboard input: %s", text), DEBUG)
-	_, err := kh.grpcClient.SendKeyboardInput(context.Background(), &pb.Text{Content: text})
-	if err != nil {
-		Debug(fmt.Sprintf("Failed to send keyboard input: %v", err), ERROR)
-	}
-}
+// browserCommand runs a navigation RPC for the active tab and reports the result in the log panel
+func (kh *KeyboardHandler) browserCommand(s tcell.Screen, name string,
+	rpc func(ctx context.Context, tab string) (*pb.Message, error)) {
+	Debug(fmt.Sprintf("%s requested", name), DEBUG)
+	logBuffer.Write([]byte(fmt.Sprintf("%s...", name)))
+	displayBottomPanel(s)
 
-// sendSpecialKey sends special keys like Enter, Tab, etc. to the server
-func (kh *KeyboardHandler) sendSpecialKey(key string) {
-	Debug(fmt.Sprintf("Sending special key: %s", key), DEBUG)
-	// We need to send a special marker for these keys
-	// The server should interpret these as keyboard.press() instead of keyboard.type()
-	specialKeyMarker := fmt.Sprintf("__KEY__%s", key)
-	_, err := kh.grpcClient.SendKeyboardInput(context.Background(), &pb.Text{Content: specialKeyMarker})
+	resp, err := rpc(context.Background(), activeTabID())
 	if err != nil {
-		Debug(fmt.Sprintf("Failed to send special key %s: %v", key, err), ERROR)
+		errorMsg := fmt.Sprintf("%s failed: %v", name, err)
+		Debug(errorMsg, ERROR)
+		logBuffer.Write([]byte(errorMsg))
+	} else {
+		logBuffer.Write([]byte(resp.Text))
 	}
-}
\ No newline at end of file
+	displayBottomPanel(s)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+	pb "termium/client/pb"
+)
+
+const (
+	// Terminals don't report auto-repeat. The same key arriving this quickly is taken to be held down:
+	// typical repeat rates are 25-40 keys per second, while nobody types one key that fast.
+	KEY_REPEAT_INTERVAL = 60 * time.Millisecond
+)
+
+// domKey is a browser key: KeyboardEvent.key and KeyboardEvent.code
+type domKey struct {
+	key  string
+	code string
+}
+
+// Keys tcell reports as their own Key value rather than a rune
+var tcellKeys = map[tcell.Key]domKey{
+	tcell.KeyUp:         {"ArrowUp", "ArrowUp"},
+	tcell.KeyDown:       {"ArrowDown", "ArrowDown"},
+	tcell.KeyLeft:       {"ArrowLeft", "ArrowLeft"},
+	tcell.KeyRight:      {"ArrowRight", "ArrowRight"},
+	tcell.KeyPgUp:       {"PageUp", "PageUp"},
+	tcell.KeyPgDn:       {"PageDown", "PageDown"},
+	tcell.KeyHome:       {"Home", "Home"},
+	tcell.KeyEnd:        {"End", "End"},
+	tcell.KeyInsert:     {"Insert", "Insert"},
+	tcell.KeyDelete:     {"Delete", "Delete"},
+	tcell.KeyEnter:      {"Enter", "Enter"},
+	tcell.KeyTab:        {"Tab", "Tab"},
+	tcell.KeyBacktab:    {"Tab", "Tab"}, // Shift is added below
+	tcell.KeyBackspace:  {"Backspace", "Backspace"},
+	tcell.KeyBackspace2: {"Backspace", "Backspace"},
+	tcell.KeyEscape:     {"Escape", "Escape"},
+	tcell.KeyPause:      {"Pause", "Pause"},
+	tcell.KeyPrint:      {"PrintScreen", "PrintScreen"},
+	tcell.KeyClear:      {"Clear", "NumpadEqual"},
+	tcell.KeyF1:         {"F1", "F1"},
+	tcell.KeyF2:         {"F2", "F2"},
+	tcell.KeyF3:         {"F3", "F3"},
+	tcell.KeyF4:         {"F4", "F4"},
+	tcell.KeyF5:         {"F5", "F5"},
+	tcell.KeyF6:         {"F6", "F6"},
+	tcell.KeyF7:         {"F7", "F7"},
+	tcell.KeyF8:         {"F8", "F8"},
+	tcell.KeyF9:         {"F9", "F9"},
+	tcell.KeyF10:        {"F10", "F10"},
+	tcell.KeyF11:        {"F11", "F11"},
+	tcell.KeyF12:        {"F12", "F12"},
+	tcell.KeyF13:        {"F13", "F13"},
+	tcell.KeyF14:        {"F14", "F14"},
+	tcell.KeyF15:        {"F15", "F15"},
+	tcell.KeyF16:        {"F16", "F16"},
+	tcell.KeyF17:        {"F17", "F17"},
+	tcell.KeyF18:        {"F18", "F18"},
+	tcell.KeyF19:        {"F19", "F19"},
+	tcell.KeyF20:        {"F20", "F20"},
+	tcell.KeyF21:        {"F21", "F21"},
+	tcell.KeyF22:        {"F22", "F22"},
+	tcell.KeyF23:        {"F23", "F23"},
+	tcell.KeyF24:        {"F24", "F24"},
+
+	// Control characters without a letter of their own
+	tcell.KeyCtrlSpace:      {" ", "Space"},
+	tcell.KeyCtrlBackslash:  {"\\", "Backslash"},
+	tcell.KeyCtrlRightSq:    {"]", "BracketRight"},
+	tcell.KeyCtrlCarat:      {"6", "Digit6"},
+	tcell.KeyCtrlUnderscore: {"-", "Minus"},
+}
+
+// Physical key of the US layout for each punctuation character, and whether it needs Shift
+var punctuationKeys = map[rune]struct {
+	code  string
+	shift bool
+}{
+	' ': {"Space", false}, '`': {"Backquote", false}, '~': {"Backquote", true},
+	'-': {"Minus", false}, '_': {"Minus", true}, '=': {"Equal", false}, '+': {"Equal", true},
+	'[': {"BracketLeft", false}, '{': {"BracketLeft", true}, ']': {"BracketRight", false}, '}': {"BracketRight", true},
+	'\\': {"Backslash", false}, '|': {"Backslash", true}, ';': {"Semicolon", false}, ':': {"Semicolon", true},
+	'\'': {"Quote", false}, '"': {"Quote", true}, ',': {"Comma", false}, '<': {"Comma", true},
+	'.': {"Period", false}, '>': {"Period", true}, '/': {"Slash", false}, '?': {"Slash", true},
+	'!': {"Digit1", true}, '@': {"Digit2", true}, '#': {"Digit3", true}, '$': {"Digit4", true},
+	'%': {"Digit5", true}, '^': {"Digit6", true}, '&': {"Digit7", true}, '*': {"Digit8", true},
+	'(': {"Digit9", true}, ')': {"Digit0", true},
+}
+
+// keyEventFromTcell translates a tcell key event into a browser key event.
+// It returns nil for keys the browser has no name for.
+func keyEventFromTcell(ev *tcell.EventKey) *pb.KeyEvent {
+	var modifiers uint32
+	mods := ev.Modifiers()
+	if mods&tcell.ModAlt != 0 {
+		modifiers |= uint32(pb.KeyModifier_KEY_MODIFIER_ALT)
+	}
+	if mods&tcell.ModCtrl != 0 {
+		modifiers |= uint32(pb.KeyModifier_KEY_MODIFIER_CTRL)
+	}
+	if mods&tcell.ModMeta != 0 {
+		modifiers |= uint32(pb.KeyModifier_KEY_MODIFIER_META)
+	}
+	if mods&tcell.ModShift != 0 {
+		modifiers |= uint32(pb.KeyModifier_KEY_MODIFIER_SHIFT)
+	}
+
+	key := ev.Key()
+	dk, named := tcellKeys[key]
+	switch {
+	case key == tcell.KeyRune:
+		r := ev.Rune()
+		// Some terminals send Ctrl+letter as the uppercase rune plus ModCtrl; without Shift the
+		// browser expects the lowercase key
+		if mods&(tcell.ModCtrl|tcell.ModShift) == tcell.ModCtrl {
+			r = unicode.ToLower(r)
+		}
+		var shift bool
+		dk, shift = runeKey(r)
+		if shift {
+			modifiers |= uint32(pb.KeyModifier_KEY_MODIFIER_SHIFT)
+		}
+	case named:
+		if key == tcell.KeyBacktab {
+			modifiers |= uint32(pb.KeyModifier_KEY_MODIFIER_SHIFT)
+		}
+	case key >= tcell.KeyCtrlA && key <= tcell.KeyCtrlZ:
+		// Ctrl+letter arrives as the control character; Tab, Enter and Backspace were matched above
+		letter := rune('a' + key - tcell.KeyCtrlA)
+		dk = domKey{string(letter), "Key" + string(unicode.ToUpper(letter))}
+		modifiers |= uint32(pb.KeyModifier_KEY_MODIFIER_CTRL)
+	default:
+		return nil
+	}
+
+	return &pb.KeyEvent{Key: dk.key, Code: dk.code, Modifiers: modifiers}
+}
+
+// runeKey returns the browser key for a typed character and whether Shift produces it on a US keyboard.
+// Characters not on a US keyboard get an empty code, which the browser accepts for composed input.
+func runeKey(r rune) (domKey, bool) {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return domKey{string(r), "Key" + string(unicode.ToUpper(r))}, false
+	case r >= 'A' && r <= 'Z':
+		return domKey{string(r), "Key" + string(r)}, true
+	case r >= '0' && r <= '9':
+		return domKey{string(r), "Digit" + string(r)}, false
+	}
+	if p, ok := punctuationKeys[r]; ok {
+		return domKey{string(r), p.code}, p.shift
+	}
+	return domKey{string(r), ""}, false
+}
+
+// The previous key, for repeat detection
+var lastKey struct {
+	key       string
+	modifiers uint32
+	time      time.Time
+}
+
+// forwardKeyEvent sends a tcell key event to the browser, returning false if it has no browser equivalent
+func forwardKeyEvent(ev *tcell.EventKey) bool {
+	event := keyEventFromTcell(ev)
+	if event == nil {
+		Debug(fmt.Sprintf("No browser key for Key=%v, Rune=%c", ev.Key(), ev.Rune()), DEBUG)
+		return false
+	}
+
+	now := ev.When()
+	event.Repeat = event.Key == lastKey.key && event.Modifiers == lastKey.modifiers &&
+		now.Sub(lastKey.time) < KEY_REPEAT_INTERVAL
+	lastKey.key, lastKey.modifiers, lastKey.time = event.Key, event.Modifiers, now
+	event.TabId = activeTabID()
+
+	queueInput(&pb.InputEvent{Event: &pb.InputEvent_Key{Key: event}})
+	return true
+}
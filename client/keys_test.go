@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+	pb "termium/client/pb"
+)
+
+func TestKeyEventFromTcell(t *testing.T) {
+	const (
+		ctrl  = uint32(pb.KeyModifier_KEY_MODIFIER_CTRL)
+		alt   = uint32(pb.KeyModifier_KEY_MODIFIER_ALT)
+		shift = uint32(pb.KeyModifier_KEY_MODIFIER_SHIFT)
+	)
+	tests := []struct {
+		name      string
+		ev        *tcell.EventKey
+		key       string
+		code      string
+		modifiers uint32
+	}{
+		{"letter", tcell.NewEventKey(tcell.KeyRune, 'a', tcell.ModNone), "a", "KeyA", 0},
+		{"capital adds shift", tcell.NewEventKey(tcell.KeyRune, 'A', tcell.ModNone), "A", "KeyA", shift},
+		{"digit", tcell.NewEventKey(tcell.KeyRune, '7', tcell.ModNone), "7", "Digit7", 0},
+		{"shifted punctuation", tcell.NewEventKey(tcell.KeyRune, '!', tcell.ModNone), "!", "Digit1", shift},
+		{"punctuation", tcell.NewEventKey(tcell.KeyRune, ',', tcell.ModNone), ",", "Comma", 0},
+		{"character off the US layout", tcell.NewEventKey(tcell.KeyRune, 'é', tcell.ModNone), "é", "", 0},
+		{"alt letter", tcell.NewEventKey(tcell.KeyRune, 'x', tcell.ModAlt), "x", "KeyX", alt},
+		{"named key", tcell.NewEventKey(tcell.KeyUp, 0, tcell.ModNone), "ArrowUp", "ArrowUp", 0},
+		{"function key", tcell.NewEventKey(tcell.KeyF5, 0, tcell.ModNone), "F5", "F5", 0},
+		{"enter", tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone), "Enter", "Enter", 0},
+		{"backtab is shift tab", tcell.NewEventKey(tcell.KeyBacktab, 0, tcell.ModNone), "Tab", "Tab", shift},
+		{"control character", tcell.NewEventKey(tcell.KeyCtrlC, 0, tcell.ModCtrl), "c", "KeyC", ctrl},
+		{"ctrl with uppercase rune", tcell.NewEventKey(tcell.KeyRune, 'L', tcell.ModCtrl), "l", "KeyL", ctrl},
+		{"ctrl shift letter", tcell.NewEventKey(tcell.KeyRune, 'L', tcell.ModCtrl|tcell.ModShift), "L", "KeyL", ctrl | shift},
+		{"shift arrow", tcell.NewEventKey(tcell.KeyLeft, 0, tcell.ModShift), "ArrowLeft", "ArrowLeft", shift},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := keyEventFromTcell(tt.ev)
+			if got == nil {
+				t.Fatal("keyEventFromTcell returned nil")
+			}
+			if got.Key != tt.key || got.Code != tt.code || got.Modifiers != tt.modifiers {
+				t.Errorf("keyEventFromTcell = {%q %q %b}, want {%q %q %b}",
+					got.Key, got.Code, got.Modifiers, tt.key, tt.code, tt.modifiers)
+			}
+		})
+	}
+}
+
+func TestKeyEventFromTcellUnnamed(t *testing.T) {
+	if got := keyEventFromTcell(tcell.NewEventKey(tcell.KeyF64, 0, tcell.ModNone)); got != nil {
+		t.Errorf("keyEventFromTcell(F64) = %+v, want nil", got)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"os"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Kitty graphics protocol - https://sw.kovidgoyal.net/kitty/graphics-protocol/
+const (
+	KITTY_IMAGE_ID     = 1    // Every frame is transmitted under the same ID so it replaces the previous one
+	KITTY_PLACEMENT_ID = 1    // Placement ID reused for the same reason
+	KITTY_CHUNK_SIZE   = 4096 // Maximum base64 payload per escape sequence allowed by the protocol
+	KITTY_QUERY_ID     = 31   // Image ID used by the capability probe, never displayed
+)
+
+// kittyRenderer transmits frames as 24-bit RGBA using the Kitty graphics protocol.
+// No palette quantization is involved, so this is both faster and more accurate than sixel.
+type kittyRenderer struct {
+	// Reusable buffers - frames are large, so avoid reallocating them every time
+	pixBuf  []byte
+	zBuf    bytes.Buffer
+	zWriter *zlib.Writer
+	stats   RendererStats
+}
+
+func newKittyRenderer() *kittyRenderer {
+	return &kittyRenderer{}
+}
+
+func (r *kittyRenderer) Name() string { return "kitty" }
+
+func (r *kittyRenderer) Init(s tcell.Screen) error { return nil }
+
+// Resize drops the old image, which could otherwise stick out past the resized panel
+func (r *kittyRenderer) Resize(widthPx, heightPx int) {
+	clearKittyImage()
+}
+
+// Every frame is sent whole, so there is nothing to invalidate
+func (r *kittyRenderer) Invalidate() {}
+
+func (r *kittyRenderer) Stats() RendererStats { return r.stats }
+
+// Close deletes the image - kitty draws it above the text layer, so tcell cannot clear it
+func (r *kittyRenderer) Close() {
+	clearKittyImage()
+}
+
+// DrawFrame transmits the frame under a fixed image ID so it replaces the previous one in place.
+// The image is replaced whole, so what changed does not matter.
+func (r *kittyRenderer) DrawFrame(s tcell.Screen, img *image.RGBA, dirty []image.Rectangle) error {
+	kittyStart := time.Now()
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil
+	}
+
+	// The protocol wants tightly packed rows; sub-images and scaled images may have a wider stride
+	rowLen := width * 4
+	pix := img.Pix
+	if img.Stride != rowLen || len(img.Pix) != rowLen*height {
+		if cap(r.pixBuf) < rowLen*height {
+			r.pixBuf = make([]byte, rowLen*height)
+		}
+		r.pixBuf = r.pixBuf[:rowLen*height]
+		for y := 0; y < height; y++ {
+			start := img.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+			copy(r.pixBuf[y*rowLen:(y+1)*rowLen], img.Pix[start:start+rowLen])
+		}
+		pix = r.pixBuf
+	}
+
+	// Compress the pixels - web pages are mostly flat color, so this shrinks the payload a lot
+	encodeStart := time.Now()
+	r.zBuf.Reset()
+	if r.zWriter == nil {
+		var err error
+		r.zWriter, err = zlib.NewWriterLevel(&r.zBuf, zlib.BestSpeed)
+		if err != nil {
+			return fmt.Errorf("kitty compression error: %v", err)
+		}
+	} else {
+		r.zWriter.Reset(&r.zBuf)
+	}
+	if _, err := r.zWriter.Write(pix); err != nil {
+		return fmt.Errorf("kitty compression error: %v", err)
+	}
+	if err := r.zWriter.Close(); err != nil {
+		return fmt.Errorf("kitty compression error: %v", err)
+	}
+	payload := base64.StdEncoding.EncodeToString(r.zBuf.Bytes())
+
+	buf := bufio.NewWriterSize(os.Stdout, 64*1024)
+	defer buf.Flush()
+
+	// Position cursor at the top-left of the usable area (after borders)
+	fmt.Fprintf(buf, "\033[%d;%dH", V_BORDER_WIDTH+1, H_BORDER_WIDTH+1)
+	buf.WriteString("\033[s")
+
+	// a=T transmit and display, f=32 RGBA, o=z zlib, q=2 suppress responses (they would
+	// otherwise arrive on stdin as key events), C=1 do not move the cursor
+	for offset := 0; offset < len(payload); offset += KITTY_CHUNK_SIZE {
+		end := offset + KITTY_CHUNK_SIZE
+		more := 1
+		if end >= len(payload) {
+			end = len(payload)
+			more = 0
+		}
+
+		buf.WriteString("\033_G")
+		if offset == 0 {
+			fmt.Fprintf(buf, "a=T,f=32,o=z,s=%d,v=%d,i=%d,p=%d,q=2,C=1,",
+				width, height, KITTY_IMAGE_ID, KITTY_PLACEMENT_ID)
+		}
+		fmt.Fprintf(buf, "m=%d;", more)
+		buf.WriteString(payload[offset:end])
+		buf.WriteString("\033\\")
+	}
+
+	// Restore cursor position
+	buf.WriteString("\033[u")
+
+	if cfg.ShowTimings {
+		fmt.Fprintf(os.Stderr, "  Kitty encode time: %v (rendered size: %dx%d pixels, %d bytes)\n",
+			time.Since(encodeStart), width, height, len(payload))
+		os.Stderr.Sync() // Force flush stderr
+	}
+
+	r.stats.record(time.Since(kittyStart), len(payload))
+	Debug(fmt.Sprintf("Displayed kitty image at (%d,%d) with size %dx%d (took %v)",
+		H_BORDER_WIDTH, V_BORDER_WIDTH, width, height, time.Since(kittyStart)), DEBUG)
+
+	return nil
+}
+
+// clearKittyImage deletes the frame image and frees its data in the terminal
+func clearKittyImage() {
+	fmt.Fprintf(os.Stdout, "\033_Ga=d,d=I,i=%d,q=2\033\\", KITTY_IMAGE_ID)
+}
+
+// kittyFromEnvironment reports whether we are running inside kitty itself,
+// for when the graphics query could not be answered
+func kittyFromEnvironment() bool {
+	return os.Getenv("KITTY_WINDOW_ID") != "" || os.Getenv("TERM") == "xterm-kitty"
+}
@@ -1,26 +1,26 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"fmt"
+	"hash/crc32"
 	"image"
 	"image/jpeg"
+	"math"
 	"os"
 	"os/signal"
 	"runtime/pprof"
 	"runtime/trace"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/gdamore/tcell/v2"
-	"github.com/mattn/go-sixel"
 	"golang.org/x/image/draw"
-	"golang.org/x/term"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
 	"google.golang.org/grpc/credentials/insecure"
 
 	pb "termium/client/pb"
@@ -28,17 +28,18 @@ import (
 
 // Screen geometry
 const (
-	LOG_PANEL_HEIGHT   = 5 // height
+	LOG_PANEL_HEIGHT   = 5 // default height, see --log-height
 	H_BORDER_WIDTH     = 1 // width in chars of all Horizontal borders
 	V_BORDER_WIDTH     = 1 // width in chars of all vertical borders
 	INTER_PANEL_BORDER = 1 // width in chars of the border between the panels
+	MIN_VIEW_HEIGHT    = 3 // height of the browser panel's borders plus one row of the page
 )
 
 // ScreenDimensions holds the current screen dimensions and panel calculations
 type ScreenDimensions struct {
 	Width           int // Total screen width
 	Height          int // Total screen height
-	LogHeight       int // Height of the log panel (constant: 5)
+	LogHeight       int // Height of the log panel (cfg.LogPanelHeight, less on short terminals)
 	LogPanelTop     int // Y coordinate where log panel starts
 	ViewHeight      int // Height of the browser panel
 	InnerWidth      int // Width minus borders
@@ -97,13 +98,24 @@ var lastScreenshotTime time.Time
 
 var lastImageNumber int = 1
 
-// Cached sixel encoder - create once, reuse many times
-var sixelEncoder *sixel.Encoder
-var sixelEncoderMutex sync.Mutex
+// Seq of the last frame applied to imageBuffer, which the next delta frame must follow
+var lastFrameSeq uint64
+
+// CRC of the data of the last keyframe, with the sixel bands' table. While imageBuffer still holds
+// that keyframe and the renderer still shows it, an identical one needs neither decoding nor
+// showing. The encoded bytes are hashed rather than the decoded image the way BandManager hashes
+// its bands: that needs no decoding, and works for every renderer, not only sixel.
+var lastKeyframeHash uint32
+
+// Cleared whenever the keyframe stops being on screen as sent: a delta was applied on top of it,
+// or the renderer was invalidated or replaced and has to draw everything again
+var lastKeyframeShown atomic.Bool
 
 // Channel to signal screenshot loop to stop
 var stopScreenshots = make(chan bool, 1)
 
+var keyboardHandler *KeyboardHandler
+
 // MenuAction represents the result of a local key event
 type MenuAction int
 
@@ -216,8 +228,9 @@ func main() {
 	var err error
 	cfg, err = parseFlags()
 	if err != nil {
-		Debug(fmt.Sprintf("Failed to parse flags: %v", err), ERROR)
-		os.Exit(1)
+		// The log panel doesn't exist yet, so this goes straight to the terminal
+		fmt.Fprintf(os.Stderr, "Error: %v\nRun %s -h for usage.\n", err, os.Args[0])
+		os.Exit(2)
 	}
 
 	// Set up CPU profiling if requested
@@ -269,10 +282,30 @@ func main() {
 		Debug("Debug mode enabled", DEBUG)
 	}
 
+	// Set up the connection before the screen so a bad address is reported in the terminal.
+	// Nothing is sent until the first RPC.
+	if err := connectToGRPCServer(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer grpcConn.Close()
+
 	detectTerminalAndCalibrate()
+	selectRenderer()
+	selectPalette()
 	s := initializeScreen()
 	defer finalizeScreen(s)
 
+	renderer, err := newRenderer(cfg.Renderer)
+	if err == nil {
+		err = setRenderer(s, renderer)
+	}
+	if err != nil {
+		Debug(fmt.Sprintf("Failed to set up renderer: %v", err), ERROR)
+		displayErrorMessage(s, fmt.Sprintf("Failed to set up renderer: %v", err))
+		return
+	}
+
 	initializeCursor()
 
 	// Show splash screen and wait for user input (unless NONE)
@@ -291,27 +324,24 @@ func main() {
 	Debug("Setting up signal handlers", DEBUG)
 	setupSignalHandling(s)
 
-	// Connect to gRPC server
-	if err := connectToGRPCServer(); err != nil {
-		Debug(fmt.Sprintf("Failed to connect to server: %v", err), ERROR)
-	}
-	defer grpcConn.Close()
-	if err := openNewTab(); err != nil {
+	keyboardHandler = NewKeyboardHandler(grpcClient)
+	go superviseConnection(s)
+	go watchConnectionState()
+	if err := openNewTab(s); err != nil {
+		// The supervisor keeps trying, and opens the start page once the server is up
 		Debug(fmt.Sprintf("Failed to open new tab: %v", err), ERROR)
+		generation, _ := connectionState()
+		connectionLost(generation, err)
+	} else {
+		// Open the start page, the home page unless a URL was given
+		go keyboardHandler.navigateToURLAsync(cfg.URL, s)
 	}
-
-	// Open home page.  For now I have it hardcoded to caffenero.com
-	if _, err := grpcClient.NavigateToUrl(
-		context.Background(),
-		//&pb.Url{Url: "https://www.caffenero.com"},
-		&pb.Url{Url: "https://kleki.com/"},
-	); err != nil {
-		Debug(fmt.Sprintf("Failed to navigate to homepage: %v", err), ERROR)
-	}
-	Debug("Successfully opened homepage", INFO)
+	// Frames, and page status from the start so the home page load shows up, come down the session
+	frames := NewFrameBuffer()
+	go runSession(s, frames)
 
 	// Start the screenshot goroutine
-	go screenshotLoop(s)
+	go screenshotLoop(s, frames)
 
 	if err := runMainLoop(s); err != nil {
 		displayErrorMessage(s, fmt.Sprintf("Error in     main loop: %v", err))
@@ -357,6 +387,10 @@ func drawBorder(s tcell.Screen) {
 	// Draw corners for bottom panel
 	s.SetContent(0, sDims.Height-1, '└', nil, borderStyle)
 	s.SetContent(sDims.Width-1, sDims.Height-1, '┘', nil, borderStyle)
+
+	// The tab strip lives in the top edge, the page status line in the middle divider
+	drawTabStrip(s)
+	drawStatusLine(s)
 }
 
 // initializeScreen creates and initializes the tcell screen
@@ -384,6 +418,7 @@ func initializeScreen() tcell.Screen {
 
 // finalizeScreen properly closes the tcell screen
 func finalizeScreen(s tcell.Screen) {
+	closeRenderer()
 	s.Fini()
 	Debug("Screen finalized", DEBUG)
 }
@@ -400,6 +435,7 @@ func setupSignalHandling(s tcell.Screen) {
 		fmt.Println("Terminal restored.")
 		os.Exit(0)
 	}()
+	watchCellSize(s)
 	Debug("Signal handlers established", DEBUG)
 }
 
@@ -416,24 +452,8 @@ func initializeCursor() {
 
 // connectToGRPCServer connects to the gRPC server
 func connectToGRPCServer() error {
-	var target string
-	
-	// Determine connection type
-	if cfg.ServerAddr != "" {
-		// TCP connection
-		if cfg.ServerAddr == "tcp" {
-			// Just --tcp flag without address, use default
-			target = "localhost:50051"
-		} else {
-			// --tcp with specific address
-			target = cfg.ServerAddr
-		}
-		Debug(fmt.Sprintf("Connecting to gRPC server via TCP at %s", target), DEBUG)
-	} else {
-		// Unix domain socket (default)
-		target = "unix:///tmp/termium.sock"
-		Debug("Connecting to gRPC server via Unix domain socket at /tmp/termium.sock", DEBUG)
-	}
+	target := cfg.ServerTarget
+	Debug(fmt.Sprintf("Connecting to gRPC server at %s", target), DEBUG)
 
 	var err error
 	// As of 1.63, the Dial() function family is deprecated in favor of
@@ -441,10 +461,23 @@ func connectToGRPCServer() error {
 	grpcConn, err = grpc.NewClient(
 		target,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		// Deadlines, shutdown cancellation and readable errors for every RPC
+		grpc.WithUnaryInterceptor(unaryRPCInterceptor),
+		grpc.WithStreamInterceptor(streamRPCInterceptor),
+		// gRPC's own reconnect delay grows up to two minutes; keep it in step with superviseConnection
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff: backoff.Config{
+				BaseDelay:  RECONNECT_INITIAL_BACKOFF,
+				Multiplier: 2,
+				Jitter:     0.2,
+				MaxDelay:   RECONNECT_MAX_BACKOFF,
+			},
+			MinConnectTimeout: RECONNECT_TIMEOUT,
+		}),
 	)
 	if err != nil {
 		Debug(fmt.Sprintf("gRPC connection failed: %v", err), ERROR)
-		return fmt.Errorf("failed to connect: %v", err)
+		return fmt.Errorf("failed to connect to %s: %v", target, err)
 	}
 	grpcClient = pb.NewBrowserControlClient(grpcConn)
 	Debug("Successfully connected to gRPC server", INFO)
@@ -452,14 +485,13 @@ func connectToGRPCServer() error {
 }
 
 // openNewTab calls the openTab method on the server
-func openNewTab() error {
-	_, err := grpcClient.OpenTab(context.Background(), &pb.Empty{})
-	if err != nil {
-		return fmt.Errorf("failed to open new tab: %v", err)
+func openNewTab(s tcell.Screen) error {
+	if err := openTab(s); err != nil {
+		return err
 	}
 	Debug("Opened new tab on the browser", DEBUG)
 
-	// Set initial viewport size after connecting
+	// Set initial viewport size after connecting; the server applies it to tabs opened later too
 	if err := updateViewportSize(); err != nil {
 		Debug(fmt.Sprintf("Failed to set initial viewport size: %v", err), ERROR)
 	}
@@ -481,60 +513,28 @@ func updateViewportSize() error {
 	return nil
 }
 
-// screenshotLoop handles the screenshot stream from the server
-func screenshotLoop(s tcell.Screen) {
-	// Start the streaming RPC
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	
-	stream, err := grpcClient.StreamScreenshots(ctx, &pb.ScreenshotRequest{
-		Fps: 10, // Request 10 FPS
-	})
-	if err != nil {
-		Debug(fmt.Sprintf("Failed to start screenshot stream: %v", err), ERROR)
-		return
-	}
-	
-	// Create frame buffer for triple buffering
-	frameBuffer := NewFrameBuffer()
-	
-	// Start receiver goroutine
-	go func() {
-		for {
-			resp, err := stream.Recv()
-			if err != nil {
-				Debug(fmt.Sprintf("Stream receive error: %v", err), ERROR)
-				return
-			}
-			
-			// Write to the current write frame
-			frame := frameBuffer.GetWriteFrame()
-			frame.Data = resp.Data
-			frame.Timestamp = time.Now()
-			
-			// Swap to make it ready for display
-			frameBuffer.SwapWriteFrame()
-		}
-	}()
-	
-	// Display loop
+// screenshotLoop displays the frames the session receives from the server
+func screenshotLoop(s tcell.Screen, frameBuffer *FrameBuffer) {
 	for {
 		select {
 		case <-stopScreenshots:
 			Debug("Screenshot loop stopped", INFO)
-			cancel()
 			return
 		default:
-			// Try to get the latest frame (non-blocking)
-			frame := frameBuffer.GetDisplayFrame()
-			if frame != nil && len(frame.Data) > 0 {
-				if err := displayFrame(s, frame, frameBuffer); err != nil {
-					Debug(fmt.Sprintf("Error displaying frame: %v", err), ERROR)
-				}
-			} else {
-				// No new frame, wait a bit
-				time.Sleep(10 * time.Millisecond)
-			}
+		}
+
+		// Blocks until the session delivers a frame; the timeout only lets the loop notice when to stop
+		frame := frameBuffer.WaitForFrame(FRAME_WAIT_TIMEOUT)
+		if frame == nil {
+			continue
+		}
+		done := paceFrame()
+		err := displayFrame(s, frame, frameBuffer)
+		done()
+		if err != nil {
+			Debug(fmt.Sprintf("Error displaying frame: %v", err), ERROR)
+		} else {
+			frameShown(frame.InputSeq)
 		}
 	}
 }
@@ -552,11 +552,11 @@ func clearDrawingArea(s tcell.Screen) {
 func displayFrame(s tcell.Screen, frame *Frame, fb *FrameBuffer) error {
 	frameStart := time.Now()
 	var decodeTime, displayTime, renderTime time.Duration
-	
-	// Only save debug screenshots if flag is enabled
-	if cfg.SaveScreenshots {
-		// Save the raw bytes first (JPEG now)
-		rawImageFile, err := os.Create(fmt.Sprintf("RawImage%03d.jpg", lastImageNumber))
+
+	// Only save debug screenshots if flag is enabled; delta frames have no image of their own
+	if cfg.SaveScreenshots && len(frame.Data) > 0 {
+		// Save the raw bytes first, as the server sent them
+		rawImageFile, err := os.Create(fmt.Sprintf("RawImage%03d.%s", lastImageNumber, frameFormatExtensions[frame.Format]))
 		if err != nil {
 			Debug(fmt.Sprintf("Error creating raw image file: %v", err), ERROR)
 		} else {
@@ -567,13 +567,70 @@ func displayFrame(s tcell.Screen, frame *Frame, fb *FrameBuffer) error {
 
 	// Measure decode time
 	decodeStart := time.Now()
-	img, err := jpeg.Decode(bytes.NewReader(frame.Data))
-	if err != nil {
-		Debug(fmt.Sprintf("Error decoding screenshot JPEG: %v", err), ERROR)
-		return err
+	var dirty []image.Rectangle // Parts of the image that changed, nil for all of it
+	var keyframeHash uint32
+	// Servers without delta frames don't number them and send every one whole
+	keyframe := frame.Keyframe || frame.Seq == 0
+	if keyframe {
+		hash := crc32.Checksum(frame.Data, crcTable)
+		screenshotMutex.Lock()
+		haveImage := imageBuffer != nil
+		screenshotMutex.Unlock()
+		if lastKeyframeShown.Load() && hash == lastKeyframeHash && haveImage {
+			lastFrameSeq = frame.Seq
+			keyframeRequested.Store(false)
+			frameUnchanged()
+			return nil
+		}
+
+		img, err := decodeFrame(frame)
+		if err != nil {
+			Debug(fmt.Sprintf("Error decoding screenshot: %v", err), ERROR)
+			return err
+		}
+		screenshotMutex.Lock()
+		imageBuffer = img
+		imageBounds = imageBuffer.Bounds()
+		screenshotMutex.Unlock()
+		keyframeRequested.Store(false)
+		// Only counts as shown once drawn, a failed draw must not make the next one skip
+		lastKeyframeShown.Store(false)
+		keyframeHash = hash
+	} else {
+		// A delta frame only applies on top of the frame before it. The image is checked and patched
+		// under one lock so a keyframe or the splash screen can't replace it in between. The keyframe
+		// is requested after unlocking, the input queue may keep it waiting.
+		screenshotMutex.Lock()
+		gap := imageBuffer == nil || frame.Seq != lastFrameSeq+1
+		var err error
+		if !gap {
+			dirty, err = applyDelta(imageBuffer, frame)
+		}
+		screenshotMutex.Unlock()
+		if gap {
+			Debug(fmt.Sprintf("Skipping delta frame %d, the last frame shown was %d", frame.Seq, lastFrameSeq), DEBUG)
+			requestKeyframe()
+			return nil
+		}
+		if err != nil {
+			Debug(fmt.Sprintf("Error applying delta frame: %v", err), ERROR)
+			requestKeyframe()
+			return err
+		}
 	}
+	lastFrameSeq = frame.Seq
 	decodeTime = time.Since(decodeStart)
 
+	// A delta frame without rects means nothing changed
+	if dirty != nil && len(dirty) == 0 {
+		frameUnchanged()
+		return nil
+	}
+	frameActivity()
+	if dirty != nil {
+		lastKeyframeShown.Store(false)
+	}
+
 	// Only save decoded image if flag is enabled
 	if cfg.SaveScreenshots {
 		// Save the decoded image as JPEG
@@ -581,7 +638,7 @@ func displayFrame(s tcell.Screen, frame *Frame, fb *FrameBuffer) error {
 		if err != nil {
 			Debug(fmt.Sprintf("Error creating output file: %v", err), ERROR)
 		} else {
-			if err := jpeg.Encode(outputFile, img, &jpeg.Options{Quality: 90}); err != nil {
+			if err := jpeg.Encode(outputFile, imageBuffer, &jpeg.Options{Quality: 90}); err != nil {
 				Debug(fmt.Sprintf("Error encoding JPEG: %v", err), ERROR)
 			}
 			outputFile.Close()
@@ -589,12 +646,6 @@ func displayFrame(s tcell.Screen, frame *Frame, fb *FrameBuffer) error {
 		lastImageNumber++
 	}
 
-	screenshotMutex.Lock()
-	imageBuffer = image.NewRGBA(img.Bounds())
-	draw.Draw(imageBuffer, img.Bounds(), img, image.Point{0, 0}, draw.Src)
-	imageBounds = imageBuffer.Bounds()
-	screenshotMutex.Unlock()
-
 	// Update the display
 	//   Only do it on first draw or after resize.  Otherwise images from the server should be the same size
 	if firstDraw {
@@ -604,7 +655,7 @@ func displayFrame(s tcell.Screen, frame *Frame, fb *FrameBuffer) error {
 
 	// Measure display time
 	displayStart := time.Now()
-	if err := displayImageBuffer(s); err != nil {
+	if err := displayImageBuffer(s, dirty); err != nil {
 		Debug(fmt.Sprintf("Error displaying image buffer: %v", err), ERROR)
 		return err
 	}
@@ -614,16 +665,25 @@ func displayFrame(s tcell.Screen, frame *Frame, fb *FrameBuffer) error {
 	renderStart := time.Now()
 	s.Show()
 	renderTime = time.Since(renderStart)
+	frameMetadataShown(frame.Metadata)
+	if keyframe {
+		lastKeyframeHash = keyframeHash
+		lastKeyframeShown.Store(true)
+	}
 
 	// Print timing info if requested
 	if cfg.ShowTimings {
 		totalTime := time.Since(frameStart)
-		
+
 		// Get frame buffer stats
 		received, displayed, dropped := fb.GetStats()
-		
-		fmt.Fprintf(os.Stderr, "Frame timings: Total=%v Decode=%v Display=%v Show=%v | Stats: Received=%d Displayed=%d Dropped=%d\n",
-			totalTime, decodeTime, displayTime, renderTime, received, displayed, dropped)
+
+		fmt.Fprintf(os.Stderr, "Frame timings: Total=%v Decode=%v Display=%v Show=%v | Stats: Received=%d Displayed=%d Dropped=%d | Input latency=%v Paint latency=%v FPS=%d\n",
+			totalTime, decodeTime, displayTime, renderTime, received, displayed, dropped, averageInputLatency(),
+			averagePaintLatency(), pacedFrameRate())
+		if r := currentRenderer(); r != nil {
+			fmt.Fprintf(os.Stderr, "  Renderer %s: %v\n", r.Name(), r.Stats())
+		}
 		os.Stderr.Sync() // Force flush stderr
 	}
 
@@ -640,14 +700,21 @@ func runMainLoop(s tcell.Screen) error {
 			Debug("Screen resize event detected", DEBUG)
 			handleResize(s)
 		case *tcell.EventKey:
-			if shouldExit := handleKeyEvent(s, ev); shouldExit {
+			if shouldExit := keyboardHandler.HandleKeyEvent(s, ev); shouldExit {
 				Debug("Exiting main loop", DEBUG)
+				// Stop the screenshot loop
+				select {
+				case stopScreenshots <- true:
+				default:
+				}
+				// Give up on RPCs still in flight rather than wait for them
+				shutdownRPCs()
 				return nil
 			}
 		case *tcell.EventMouse:
 			handleMouseEvent(s, ev)
 		case *tcell.EventInterrupt:
-			handleInterrupt(s)
+			handleInterrupt(s, ev)
 		}
 	}
 }
@@ -655,16 +722,22 @@ func runMainLoop(s tcell.Screen) error {
 // updateScreenDimensions updates the screen dimensions struct
 func updateScreenDimensions(s tcell.Screen) {
 	width, height := s.Size()
+	// A log panel as tall as the terminal would leave no room for the page; it gives way first
+	logHeight := cfg.LogPanelHeight
+	if logHeight > height-MIN_VIEW_HEIGHT {
+		logHeight = max(height-MIN_VIEW_HEIGHT, 0)
+		Debug(fmt.Sprintf("Log panel shrunk to %d lines to fit a terminal of %d lines", logHeight, height), DEBUG)
+	}
 	sDims = ScreenDimensions{
 		Width:           width,
 		Height:          height,
-		LogHeight:       LOG_PANEL_HEIGHT,
-		ViewHeight:      height - LOG_PANEL_HEIGHT,
-		LogPanelTop:     height - LOG_PANEL_HEIGHT,
+		LogHeight:       logHeight,
+		ViewHeight:      height - logHeight,
+		LogPanelTop:     height - logHeight,
 		InnerWidth:      width - (2 * H_BORDER_WIDTH),
-		InnerViewHeight: height - LOG_PANEL_HEIGHT - (2 * V_BORDER_WIDTH),
+		InnerViewHeight: height - logHeight - (2 * V_BORDER_WIDTH),
 		InnerWidthPx:    (width - (2 * H_BORDER_WIDTH)) * charSize.Width,
-		InnerHeightPx:   (height - LOG_PANEL_HEIGHT - (2 * V_BORDER_WIDTH)) * charSize.Height,
+		InnerHeightPx:   (height - logHeight - (2 * V_BORDER_WIDTH)) * charSize.Height,
 	}
 	Debug(fmt.Sprintf("Screen dimensions updated: %+v", sDims), DEBUG)
 }
@@ -673,21 +746,40 @@ func updateScreenDimensions(s tcell.Screen) {
 func handleResize(s tcell.Screen) {
 	Debug("Resize event", DEBUG)
 	s.Clear()
+	recalibrate()
 	updateScreenDimensions(s)
 
-	// Update server with new viewport size
-	if err := updateViewportSize(); err != nil {
-		Debug(fmt.Sprintf("Failed to update viewport size after resize: %v", err), ERROR)
-	}
+	// Update server with new viewport size, in order with the input around it
+	queueViewportSize()
 
+	resizeRenderer()
 	drawBorder(s)
 	clearDrawingArea(s)
+	invalidateRenderer()
 	s.Sync()
 	// No need to redisplay static content, as the screenshot will be updated by the goroutine
 }
 
 // handleInterrupt handles interrupt events for updating the display
-func handleInterrupt(s tcell.Screen) {
+func handleInterrupt(s tcell.Screen, ev *tcell.EventInterrupt) {
+	switch ev.Data() {
+	case redrawTabStrip:
+		drawTabStrip(s)
+		// The active tab may have changed, and with it the status to show
+		drawStatusLine(s)
+		s.Show()
+		return
+	case redrawStatusLine:
+		drawStatusLine(s)
+		s.Show()
+		return
+	case checkCellSize:
+		resizeOnCellSizeChange(s)
+		return
+	case promptForURL:
+		keyboardHandler.enterURLMode(s, "")
+		return
+	}
 	blinkCursor(s)
 	displayMouseInfo(s)
 }
@@ -702,98 +794,8 @@ func handleMouseEvent(s tcell.Screen, ev *tcell.EventMouse) {
 
 	displayMouseInfo(s)
 
-	// Handle mouse click
-	button := ev.Buttons()
-	if button&tcell.Button1 != 0 {
-		go sendMouseClick(currentMouse.PixelX, currentMouse.PixelY)
-	}
-}
-
-// sendMouseClick sends a mouse click event to the server
-func sendMouseClick(x, y int) {
-	Debug(fmt.Sprintf("Sending mouse click at (%d, %d)", x, y), DEBUG)
-	_, err := grpcClient.ClickMouse(context.Background(), &pb.Coordinate{X: int32(x), Y: int32(y)})
-	if err != nil {
-		Debug(fmt.Sprintf("Failed to send mouse click: %v", err), ERROR)
-	}
-}
-
-// Handle keyboard within the browser context - returns true if should exit
-func handleKeyEvent(s tcell.Screen, ev *tcell.EventKey) bool {
-	oldX, oldY := cursor.x, cursor.y
-
-	if ev.Modifiers()&tcell.ModCtrl != 0 {
-		// Ctrl+Key combinations
-		switch ev.Key() {
-		case tcell.KeyUp:
-			if ev.Modifiers()&tcell.ModCtrl != 0 {
-				// Handle Ctrl+Up
-				Debug("Ctrl+Up pressed", DEBUG)
-			} else {
-				// Handle regular Up key
-			}
-		case tcell.KeyDown:
-			if ev.Modifiers()&tcell.ModCtrl != 0 {
-				// Handle Ctrl+Down
-				Debug("Ctrl+Down pressed", DEBUG)
-			} else {
-				// Handle regular Down key
-			}
-		case tcell.KeyLeft:
-			if ev.Modifiers()&tcell.ModCtrl != 0 {
-				// Handle Ctrl+Left
-				Debug("Ctrl+Left pressed", DEBUG)
-			} else {
-				// Handle regular Left key
-			}
-		case tcell.KeyRight:
-			if ev.Modifiers()&tcell.ModCtrl != 0 {
-				// Handle Ctrl+Right
-				Debug("Ctrl+Right pressed", DEBUG)
-			} else {
-				// Handle regular Right key
-			}
-		}
-	} else {
-		// Regular keys
-		switch ev.Key() {
-		case tcell.KeyEscape:
-			Debug("Exit key pressed", DEBUG)
-			// Stop the screenshot loop
-			select {
-			case stopScreenshots <- true:
-			default:
-			}
-			s.Fini()
-			return true // Signal to exit
-		case tcell.KeyUp:
-			if cursor.y > V_BORDER_WIDTH {
-				cursor.y--
-			}
-		case tcell.KeyDown:
-			if cursor.y < sDims.Height-(V_BORDER_WIDTH+sDims.LogHeight) {
-				cursor.y++
-			}
-		case tcell.KeyLeft:
-			if cursor.x > H_BORDER_WIDTH {
-				cursor.x--
-			}
-		case tcell.KeyRight:
-			if cursor.x < sDims.Width-H_BORDER_WIDTH {
-				cursor.x++
-			}
-		default:
-			// Send keyboard input to server
-			go sendKeyboardInput(string(ev.Rune()))
-		}
-	}
-
-	if oldX != cursor.x || oldY != cursor.y {
-		redrawImageArea(s, oldX, oldY)
-		redrawImageArea(s, cursor.x, cursor.y)
-	}
-	
-	return false // Don't exit
+	handleTabStripClick(s, x, y, ev.Buttons())
+	forwardMouseEvent(x, y, ev.Buttons())
 }
 
 func handleLocalKeyEvent(ev *tcell.EventKey) MenuAction {
@@ -832,107 +834,9 @@ func handleLocalKeyEvent(ev *tcell.EventKey) MenuAction {
 	return MenuNone
 }
 
-// detectTerminalAndCalibrate detects the terminal type and calibrates the character size
-func detectTerminalAndCalibrate() {
-	termType := os.Getenv("TERM")
-	Debug(fmt.Sprintf("Terminal type: %s", termType), DEBUG)
-
-	if strings.HasPrefix(termType, "xterm") || strings.Contains(termType, "256color") {
-		Debug("xterm-compatible terminal detected. Attempting to calibrate.", DEBUG)
-		if err := calibrateXterm(); err != nil {
-			Debug(fmt.Sprintf("Terminal calibration failed: %v", err), WARN)
-			Debug("Falling back to default character size", INFO)
-			setDefaultCharSize()
-		}
-	} else {
-		Debug("Non-xterm terminal detected, using defaults", DEBUG)
-		setDefaultCharSize()
-	}
-}
-
-// calibrateXterm calibrates the character size for xterm-compatible terminals
-func calibrateXterm() error {
-	Debug("Starting terminal calibration", DEBUG)
-
-	charResponse, err := queryTerminal("\033[18t")
-	if err != nil {
-		Debug(fmt.Sprintf("Terminal character query failed: %v", err), ERROR)
-		return fmt.Errorf("terminal query failed: %v", err)
-	}
-	Debug(fmt.Sprintf("Raw character response: %q", charResponse), DEBUG)
-
-	pixelResponse, err := queryTerminal("\033[14t")
-	if err != nil {
-		Debug(fmt.Sprintf("Terminal pixel query failed: %v", err), ERROR)
-		return fmt.Errorf("pixel query failed: %v", err)
-	}
-	Debug(fmt.Sprintf("Raw pixel response: %q", pixelResponse), DEBUG)
-
-	var charRows, charCols, pixelHeight, pixelWidth int
-	_, err = fmt.Sscanf(charResponse, "\033[8;%d;%dt", &charRows, &charCols)
-	if err != nil {
-		Debug(fmt.Sprintf("Failed to parse character dimensions: %v", err), ERROR)
-		return fmt.Errorf("parse error: %v", err)
-	}
-	Debug(fmt.Sprintf("Parsed character dimensions - Rows: %d, Cols: %d", charRows, charCols), DEBUG)
-
-	_, err = fmt.Sscanf(pixelResponse, "\033[4;%d;%dt", &pixelHeight, &pixelWidth)
-	if err != nil {
-		Debug(fmt.Sprintf("Failed to parse pixel dimensions: %v", err), ERROR)
-		return fmt.Errorf("parse error: %v", err)
-	}
-	Debug(fmt.Sprintf("Parsed pixel dimensions - Height: %d, Width: %d", pixelHeight, pixelWidth), DEBUG)
-
-	// Check for zero values to avoid division by zero
-	if charRows == 0 || charCols == 0 {
-		Debug("Invalid character dimensions (zero values detected)", ERROR)
-		return fmt.Errorf("invalid character dimensions")
-	}
-
-	charSize.Width = pixelWidth / charCols
-	charSize.Height = pixelHeight / charRows
-
-	Debug(fmt.Sprintf("Calibrated character size: %dx%d pixels", charSize.Width, charSize.Height), INFO)
-
-	// Sanity check the results
-	if charSize.Width < 1 || charSize.Height < 1 {
-		Debug(fmt.Sprintf("Unreasonable character size calculated: %dx%d", charSize.Width, charSize.Height), ERROR)
-		return fmt.Errorf("unreasonable character size calculated")
-	}
-
-	return nil
-}
-
-// queryTerminal sends a query to the terminal and returns the response
-func queryTerminal(query string) (string, error) {
-	_, err := fmt.Fprint(os.Stdout, query)
-	if err != nil {
-		return "", err
-	}
-
-	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
-	if err != nil {
-		return "", err
-	}
-	defer term.Restore(int(os.Stdin.Fd()), oldState)
-
-	response := make([]byte, 32)
-	n, err := os.Stdin.Read(response)
-	if err != nil {
-		return "", err
-	}
-
-	return string(response[:n]), nil
-}
-
-// setDefaultCharSize sets default character size when calibration fails
-func setDefaultCharSize() {
-	charSize = CharSize{Width: 8, Height: 16}
-	Debug("Using default character size: 8x16 pixels", DEBUG)
-}
-
-// Displays the image buffer using either sixel or character-based rendering within tcell's framework
-func displayImageBuffer(s tcell.Screen) error {
+// Displays the image buffer with the active renderer within tcell's framework.
+// dirty lists the parts of the image buffer that changed since it was last displayed, nil if unknown.
+func displayImageBuffer(s tcell.Screen, dirty []image.Rectangle) error {
 	if s == nil || imageBuffer == nil {
 		return fmt.Errorf("invalid screen or image buffer")
 	}
@@ -953,14 +857,27 @@ func displayImageBuffer(s tcell.Screen) error {
 
 	// Scale image to fit available space
 	scaledImage := scaleImage(imageBuffer, maxWidthPx, maxHeightPx)
+	frameShownAt(scaledImage.Bounds().Size())
 
-	if cfg.UseTCell {
-		// Fallback to character-based rendering for terminals without sixel
-		return displayWithTcell(s, scaledImage)
-	}
+	return drawWithRenderer(s, scaledImage, scaleRects(dirty, imageBuffer.Bounds(), scaledImage.Bounds()))
+}
 
-	// Use sixel rendering while respecting tcell boundaries
-	return displayWithSixel(scaledImage)
+// scaleRects maps rects in from onto the scaled image to. Edges are rounded outwards and widened by
+// a pixel, as scaling blends neighbouring pixels.
+func scaleRects(rects []image.Rectangle, from, to image.Rectangle) []image.Rectangle {
+	if rects == nil || from.Size() == to.Size() {
+		return rects
+	}
+	scaleX := float64(to.Dx()) / float64(from.Dx())
+	scaleY := float64(to.Dy()) / float64(from.Dy())
+	scaled := make([]image.Rectangle, 0, len(rects))
+	for _, r := range rects {
+		scaled = append(scaled, image.Rect(
+			int(math.Floor(float64(r.Min.X)*scaleX))-1, int(math.Floor(float64(r.Min.Y)*scaleY))-1,
+			int(math.Ceil(float64(r.Max.X)*scaleX))+1, int(math.Ceil(float64(r.Max.Y)*scaleY))+1,
+		).Intersect(to))
+	}
+	return scaled
 }
 
 // Scales image efficiently using shared logic
@@ -997,88 +914,6 @@ func scaleImage(src *image.RGBA, targetWidth, targetHeight int) *image.RGBA {
 	return scaled
 }
 
-// displayWithSixel uses the Go sixel library
-func displayWithSixel(img *image.RGBA) error {
-	sixelStart := time.Now()
-	
-	buf := bufio.NewWriter(os.Stdout)
-	defer buf.Flush() // Ensures all data is written before function returns
-
-	bounds := img.Bounds()
-	if bounds.Dx() > sDims.InnerWidthPx || bounds.Dy() > sDims.InnerHeightPx {
-		Debug(fmt.Sprintf("Image dimensions %dx%d exceed available space %dx%d",
-			bounds.Dx(), bounds.Dy(),
-			sDims.InnerWidthPx, sDims.InnerHeightPx), WARN)
-	}
-
-	// Position cursor at the top-left of the usable area (after borders)
-	// Add 1 to border width because terminal coordinates are 1-based
-	fmt.Printf("\033[%d;%dH", V_BORDER_WIDTH+1, H_BORDER_WIDTH+1)
-
-	// Save cursor position before sixel output
-	fmt.Print("\033[s")
-
-	// Initialize encoder once on first use
-	sixelEncoderMutex.Lock()
-	if sixelEncoder == nil {
-		sixelEncoder = sixel.NewEncoder(os.Stdout)
-		sixelEncoder.Dither = false  // Disable dithering for speed
-		
-		// Set palette based on config
-		switch cfg.Palette {
-		case "websafe":
-			sixelEncoder.Palette = sixel.PaletteWebSafe
-		case "plan9":
-			sixelEncoder.Palette = sixel.PalettePlan9
-		default: // "adaptive"
-			sixelEncoder.Palette = sixel.PaletteAdaptive
-		}
-		
-		// TODO: When adding support for other protocols (Kitty, iTerm2, etc),
-		// adjust color depth based on protocol capabilities:
-		// - Sixel: 256 colors max
-		// - Kitty: 24-bit true color support
-		// - iTerm2: 24-bit true color support
-		Debug("Created sixel encoder (one-time initialization)", INFO)
-	}
-	
-	// Update dimensions for this frame
-	sixelEncoder.Width = img.Bounds().Dx()
-	sixelEncoder.Height = img.Bounds().Dy()
-	sixelEncoderMutex.Unlock()
-
-	// Encode the image
-	encodeStart := time.Now()
-	if err := sixelEncoder.Encode(img); err != nil {
-		Debug(fmt.Sprintf("Sixel encoding error: %v", err), ERROR)
-		return fmt.Errorf("sixel encoding error: %v", err)
-	}
-	
-	if cfg.ShowTimings {
-		// Get cache stats if using fixed palette
-		hits, misses, hitRate := sixelEncoder.GetCacheStats()
-		if hits > 0 || misses > 0 {
-			fmt.Fprintf(os.Stderr, "Cache stats: hits=%d misses=%d (%.1f%% hit rate)\n", 
-				hits, misses, hitRate)
-		}
-		
-		fmt.Fprintf(os.Stderr, "  Sixel encode time: %v (rendered size: %dx%d pixels)\n", 
-			time.Since(encodeStart), img.Bounds().Dx(), img.Bounds().Dy())
-		os.Stderr.Sync() // Force flush stderr
-	}
-
-	// Restore cursor position
-	fmt.Print("\033[u")
-
-	Debug(fmt.Sprintf("Displayed sixel image at (%d,%d) with size %dx%d (took %v)",
-		H_BORDER_WIDTH, V_BORDER_WIDTH,
-		img.Bounds().Dx(), img.Bounds().Dy(),
-		time.Since(sixelStart)), DEBUG)
-
-	return nil
-}
-
-
 // Displays log messages in the bottom panel with navy background
 func displayBottomPanel(s tcell.Screen) error {
 	baseStyle := tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorNavy)
@@ -1157,7 +992,7 @@ func displayMouseInfo(s tcell.Screen) {
 
 // Displays usage instructions in the bottom panel
 func displayInstructions(s tcell.Screen) {
-	message := "Use arrow keys to move cursor. Mouse over image for coordinates. Press ESC or Ctrl+C to exit"
+	message := "Press u to open a URL, i to type into the page (Esc to leave), Ctrl+Q to exit"
 	logBuffer.Write([]byte(message))
 	displayBottomPanel(s)
 }
@@ -1213,7 +1048,7 @@ func showSplashScreen(s tcell.Screen, splashPath string) error {
 	clearDrawingArea(s)
 	// TODO: Uncomment this.:
 	// Display initial image
-	if err := displayImageBuffer(s); err != nil {
+	if err := displayImageBuffer(s, nil); err != nil {
 		return fmt.Errorf("failed to display splash image: %v", err)
 	}
 
@@ -1225,6 +1060,10 @@ func showSplashScreen(s tcell.Screen, splashPath string) error {
 	for {
 		ev := s.PollEvent()
 		switch ev := ev.(type) {
+		case *tcell.EventInterrupt:
+			if ev.Data() == checkCellSize {
+				resizeOnCellSizeChange(s)
+			}
 		case *tcell.EventKey:
 			Debug(fmt.Sprintf("Splash screen received key event: %v", ev.Key()), DEBUG)
 			action := handleLocalKeyEvent(ev)
@@ -1237,8 +1076,11 @@ func showSplashScreen(s tcell.Screen, splashPath string) error {
 				return nil
 			}
 		case *tcell.EventResize:
+			recalibrate()
 			updateScreenDimensions(s)
-			if err := displayImageBuffer(s); err != nil {
+			resizeRenderer()
+			invalidateRenderer()
+			if err := displayImageBuffer(s, nil); err != nil {
 				return fmt.Errorf("failed to redisplay splash image after resize: %v", err)
 			}
 			Debug(fmt.Sprintf("Displayed imge %v by %v", imageBuffer.Bounds().Size().X, imageBuffer.Bounds().Size().Y), INFO)
@@ -1247,12 +1089,3 @@ func showSplashScreen(s tcell.Screen, splashPath string) error {
 		}
 	}
 }
-
-// sendKeyboardInput sends keyboard input to the server
-func sendKeyboardInput(text string) {
-	Debug(fmt.Sprintf("Sending keyboard input: %s", text), DEBUG)
-	_, err := grpcClient.SendKeyboardInput(context.Background(), &pb.Text{Content: text})
-	if err != nil {
-		Debug(fmt.Sprintf("Failed to send keyboard input: %v", err), ERROR)
-	}
-}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"reflect"
+	"testing"
+
+	pb "termium/client/pb"
+)
+
+// resetFrameState forgets the frames shown by earlier tests
+func resetFrameState(t *testing.T) {
+	t.Helper()
+	cfg = &Config{FPS: DEFAULT_FPS}
+	imageBuffer = nil
+	lastFrameSeq = 0
+	lastKeyframeShown.Store(false)
+	keyframeRequested.Store(false)
+	for len(inputQueue) > 0 {
+		<-inputQueue
+	}
+}
+
+// rgbaFrame returns a raw keyframe of the given size filled with one gray level
+func rgbaFrame(seq uint64, width, height int, gray byte) *Frame {
+	pix := make([]byte, width*height*4)
+	for i := range pix {
+		pix[i] = gray
+	}
+	return &Frame{Data: pix, Format: pb.FrameFormat_FRAME_FORMAT_RGBA, Width: width, Height: height,
+		Stride: width * 4, Seq: seq, Keyframe: true}
+}
+
+// deltaFrame returns a delta frame that sets the given rects to one gray level
+func deltaFrame(seq uint64, gray byte, rects ...image.Rectangle) *Frame {
+	frame := &Frame{Format: pb.FrameFormat_FRAME_FORMAT_RGBA, Seq: seq}
+	for _, r := range rects {
+		data := make([]byte, r.Dx()*r.Dy()*4)
+		for i := range data {
+			data[i] = gray
+		}
+		frame.Rects = append(frame.Rects, &pb.DirtyRect{X: int32(r.Min.X), Y: int32(r.Min.Y),
+			Width: int32(r.Dx()), Height: int32(r.Dy()), Data: data})
+	}
+	return frame
+}
+
+func TestDisplayFrameDispatch(t *testing.T) {
+	s := testScreen(t)
+	fb := NewFrameBuffer()
+
+	t.Run("keyframe draws the whole image", func(t *testing.T) {
+		resetFrameState(t)
+		r := useFakeRenderer(t, s)
+		if err := displayFrame(s, rgbaFrame(1, 32, 16, 0x10), fb); err != nil {
+			t.Fatalf("displayFrame: %v", err)
+		}
+		if len(r.drawn) != 1 || r.drawn[0].size != image.Pt(32, 16) || r.drawn[0].dirty != nil {
+			t.Fatalf("drawn %+v, want one whole 32x16 frame", r.drawn)
+		}
+		if lastFrameSeq != 1 {
+			t.Errorf("lastFrameSeq = %d, want 1", lastFrameSeq)
+		}
+	})
+
+	t.Run("delta draws only its rects", func(t *testing.T) {
+		resetFrameState(t)
+		r := useFakeRenderer(t, s)
+		displayFrame(s, rgbaFrame(1, 32, 16, 0x10), fb)
+		rect := image.Rect(8, 4, 16, 12)
+		if err := displayFrame(s, deltaFrame(2, 0xff, rect), fb); err != nil {
+			t.Fatalf("displayFrame: %v", err)
+		}
+		if len(r.drawn) != 2 {
+			t.Fatalf("drew %d frames, want 2", len(r.drawn))
+		}
+		if dirty := r.drawn[1].dirty; len(dirty) != 1 || dirty[0] != rect {
+			t.Errorf("delta dirty = %v, want [%v]", dirty, rect)
+		}
+		if got := imageBuffer.RGBAAt(10, 6).R; got != 0xff {
+			t.Errorf("pixel inside the delta = %#x, want 0xff", got)
+		}
+		if got := imageBuffer.RGBAAt(0, 0).R; got != 0x10 {
+			t.Errorf("pixel outside the delta = %#x, want 0x10", got)
+		}
+	})
+
+	t.Run("empty delta draws nothing", func(t *testing.T) {
+		resetFrameState(t)
+		r := useFakeRenderer(t, s)
+		displayFrame(s, rgbaFrame(1, 32, 16, 0x10), fb)
+		if err := displayFrame(s, deltaFrame(2, 0), fb); err != nil {
+			t.Fatalf("displayFrame: %v", err)
+		}
+		if len(r.drawn) != 1 {
+			t.Errorf("drew %d frames, want only the keyframe", len(r.drawn))
+		}
+		if lastFrameSeq != 2 {
+			t.Errorf("lastFrameSeq = %d, want 2", lastFrameSeq)
+		}
+	})
+
+	t.Run("delta after a gap requests a keyframe", func(t *testing.T) {
+		resetFrameState(t)
+		r := useFakeRenderer(t, s)
+		displayFrame(s, rgbaFrame(1, 32, 16, 0x10), fb)
+		if err := displayFrame(s, deltaFrame(3, 0xff, image.Rect(0, 0, 8, 8)), fb); err != nil {
+			t.Fatalf("displayFrame: %v", err)
+		}
+		if len(r.drawn) != 1 {
+			t.Errorf("drew %d frames, want only the keyframe", len(r.drawn))
+		}
+		if !keyframeRequested.Load() {
+			t.Error("no keyframe requested")
+		}
+		select {
+		case item := <-inputQueue:
+			if item.control.GetRequestKeyframe() == nil {
+				t.Errorf("queued %+v, want a keyframe request", item)
+			}
+		default:
+			t.Error("keyframe request not queued")
+		}
+	})
+
+	t.Run("delta without a keyframe requests one", func(t *testing.T) {
+		resetFrameState(t)
+		r := useFakeRenderer(t, s)
+		if err := displayFrame(s, deltaFrame(1, 0xff, image.Rect(0, 0, 8, 8)), fb); err != nil {
+			t.Fatalf("displayFrame: %v", err)
+		}
+		if len(r.drawn) != 0 || !keyframeRequested.Load() {
+			t.Errorf("drew %d frames, keyframe requested %v; want none drawn and a request", len(r.drawn), keyframeRequested.Load())
+		}
+	})
+
+	t.Run("identical keyframe draws nothing", func(t *testing.T) {
+		resetFrameState(t)
+		r := useFakeRenderer(t, s)
+		displayFrame(s, rgbaFrame(1, 32, 16, 0x10), fb)
+		displayFrame(s, rgbaFrame(2, 32, 16, 0x10), fb)
+		if len(r.drawn) != 1 {
+			t.Errorf("drew %d frames, want only the first", len(r.drawn))
+		}
+		displayFrame(s, rgbaFrame(3, 32, 16, 0x20), fb)
+		if len(r.drawn) != 2 {
+			t.Errorf("drew %d frames, want the changed keyframe too", len(r.drawn))
+		}
+	})
+
+	t.Run("identical keyframe drawn after a failed draw", func(t *testing.T) {
+		resetFrameState(t)
+		r := useFakeRenderer(t, s)
+		r.err = errors.New("terminal gone")
+		if err := displayFrame(s, rgbaFrame(1, 32, 16, 0x10), fb); err == nil {
+			t.Fatal("displayFrame succeeded with a failing renderer")
+		}
+		r.err = nil
+		displayFrame(s, rgbaFrame(2, 32, 16, 0x10), fb)
+		if len(r.drawn) != 2 {
+			t.Errorf("drew %d frames, want the keyframe again after the failed draw", len(r.drawn))
+		}
+	})
+
+	t.Run("identical keyframe drawn after invalidation", func(t *testing.T) {
+		resetFrameState(t)
+		r := useFakeRenderer(t, s)
+		displayFrame(s, rgbaFrame(1, 32, 16, 0x10), fb)
+		invalidateRenderer()
+		displayFrame(s, rgbaFrame(2, 32, 16, 0x10), fb)
+		if len(r.drawn) != 2 {
+			t.Errorf("drew %d frames after invalidation, want the keyframe again", len(r.drawn))
+		}
+		if err := setRenderer(s, r); err != nil {
+			t.Fatalf("setRenderer: %v", err)
+		}
+		displayFrame(s, rgbaFrame(3, 32, 16, 0x10), fb)
+		if len(r.drawn) != 3 {
+			t.Errorf("drew %d frames after a renderer switch, want the keyframe again", len(r.drawn))
+		}
+	})
+}
+
+func TestScaleRects(t *testing.T) {
+	tests := []struct {
+		name     string
+		rects    []image.Rectangle
+		from, to image.Rectangle
+		want     []image.Rectangle
+	}{
+		{"unknown stays unknown", nil, image.Rect(0, 0, 100, 100), image.Rect(0, 0, 50, 50), nil},
+		{"same size unchanged", []image.Rectangle{image.Rect(10, 10, 20, 20)},
+			image.Rect(0, 0, 100, 100), image.Rect(0, 0, 100, 100), []image.Rectangle{image.Rect(10, 10, 20, 20)}},
+		{"halved with a pixel of margin", []image.Rectangle{image.Rect(10, 20, 30, 40)},
+			image.Rect(0, 0, 100, 100), image.Rect(0, 0, 50, 50), []image.Rectangle{image.Rect(4, 9, 16, 21)}},
+		{"odd edges round outwards", []image.Rectangle{image.Rect(11, 11, 13, 13)},
+			image.Rect(0, 0, 100, 100), image.Rect(0, 0, 50, 50), []image.Rectangle{image.Rect(4, 4, 8, 8)}},
+		{"clipped to the target", []image.Rectangle{image.Rect(0, 0, 100, 10)},
+			image.Rect(0, 0, 100, 100), image.Rect(0, 0, 50, 50), []image.Rectangle{image.Rect(0, 0, 50, 6)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scaleRects(tt.rects, tt.from, tt.to); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scaleRects = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateScreenDimensionsFitsLogPanel(t *testing.T) {
+	tests := []struct {
+		name           string
+		height         int
+		logPanelHeight int
+		wantLogHeight  int
+	}{
+		{"room for both", 24, 5, 5},
+		{"log panel as tall as the terminal", 24, 24, 21},
+		{"log panel taller than the terminal", 24, 40, 21},
+		{"terminal too short for any log", 2, 5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testScreen(t)
+			s.SetSize(80, tt.height)
+			cfg = &Config{LogPanelHeight: tt.logPanelHeight}
+			updateScreenDimensions(s)
+			if sDims.LogHeight != tt.wantLogHeight {
+				t.Errorf("LogHeight = %d, want %d", sDims.LogHeight, tt.wantLogHeight)
+			}
+			if sDims.LogPanelTop != tt.height-tt.wantLogHeight {
+				t.Errorf("LogPanelTop = %d, want %d", sDims.LogPanelTop, tt.height-tt.wantLogHeight)
+			}
+			if tt.height >= MIN_VIEW_HEIGHT && sDims.InnerViewHeight < 1 {
+				t.Errorf("InnerViewHeight = %d, want at least 1", sDims.InnerViewHeight)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	pb "termium/client/pb"
+)
+
+const (
+	DOUBLE_CLICK_INTERVAL = 400 * time.Millisecond // Max time between presses of a double click
+	WHEEL_SCROLL_DELTA    = 100                    // Pixels scrolled per wheel notch, like a desktop browser
+)
+
+// The mouse buttons we forward, and what the browser calls them.
+// tcell reports the right button as Button2 and the middle one as Button3.
+var mouseButtons = []struct {
+	mask   tcell.ButtonMask
+	button pb.MouseButton
+}{
+	{tcell.Button1, pb.MouseButton_MOUSE_BUTTON_LEFT},
+	{tcell.Button2, pb.MouseButton_MOUSE_BUTTON_RIGHT},
+	{tcell.Button3, pb.MouseButton_MOUSE_BUTTON_MIDDLE},
+}
+
+const mouseButtonMask = tcell.Button1 | tcell.Button2 | tcell.Button3
+
+// moveSlot is the place in the input queue of a mouse move. Moves arriving while it waits replace
+// its position, so a fast mouse doesn't fill the input queue with positions that are already stale.
+type moveSlot struct {
+	event *pb.MouseEvent
+}
+
+// The slot of the last queued move while later moves may still update it. Anything else queued
+// behind it closes it: the moves after a click must not overtake the click.
+var pendingMove struct {
+	sync.Mutex
+	slot *moveSlot
+}
+
+// Mouse state as seen by the event loop
+var mouseState struct {
+	buttons      tcell.ButtonMask // Buttons held at the last event
+	lastX, lastY int              // Last pixel position sent to the browser
+	clickButton  tcell.ButtonMask // Button of the last press, for double click detection
+	clickTime    time.Time
+	clickX       int
+	clickY       int
+	clickCount   int
+}
+
+// takePendingMove returns the move waiting in a slot, nil if it was sent already
+func takePendingMove(slot *moveSlot) *pb.InputEvent {
+	pendingMove.Lock()
+	defer pendingMove.Unlock()
+	if pendingMove.slot == slot {
+		pendingMove.slot = nil
+	}
+	event := slot.event
+	slot.event = nil
+	if event == nil {
+		return nil
+	}
+	return &pb.InputEvent{Event: &pb.InputEvent_MouseMove{MouseMove: event}}
+}
+
+// closeMoveSlot makes the next move take a new place in the queue, behind whatever is queued now
+func closeMoveSlot() {
+	pendingMove.Lock()
+	pendingMove.slot = nil
+	pendingMove.Unlock()
+}
+
+// queueMouseMove records a move, queueing it only if the last queued item is not a move still
+// waiting to be sent
+func queueMouseMove(x, y int) {
+	event := &pb.MouseEvent{X: int32(x), Y: int32(y), TabId: activeTabID()}
+	pendingMove.Lock()
+	if pendingMove.slot != nil {
+		pendingMove.slot.event = event
+		pendingMove.Unlock()
+		return
+	}
+	slot := &moveSlot{event: event}
+	pendingMove.slot = slot
+	pendingMove.Unlock()
+
+	if !enqueueInput(queuedInput{move: slot}) {
+		// Nothing will pick the move up, so let the next one queue itself again
+		closeMoveSlot()
+	}
+}
+
+// forwardMouseEvent turns a tcell mouse event at cell (x, y) into browser mouse events.
+// tcell only reports which buttons are held, so presses and releases are found by comparing
+// with the previous event.
+func forwardMouseEvent(x, y int, buttons tcell.ButtonMask) {
+	inside := insideBrowserPanel(x, y)
+	held := buttons & mouseButtonMask
+	if !inside {
+		// Presses outside the browser are not ours; a drag that leaves it still is
+		held &= mouseState.buttons
+		if held == 0 && mouseState.buttons == 0 {
+			return
+		}
+	}
+
+	px, py := pagePoint(clampToBrowserPanel(currentMouse.PixelX, currentMouse.PixelY))
+
+	// Hover and drag
+	if px != mouseState.lastX || py != mouseState.lastY {
+		mouseState.lastX, mouseState.lastY = px, py
+		queueMouseMove(px, py)
+	}
+
+	for _, b := range mouseButtons {
+		wasHeld := mouseState.buttons&b.mask != 0
+		isHeld := held&b.mask != 0
+		switch {
+		case isHeld && !wasHeld:
+			event := &pb.MouseEvent{X: int32(px), Y: int32(py), Button: b.button,
+				ClickCount: int32(countClick(b.mask, x, y)), TabId: activeTabID()}
+			queueInput(&pb.InputEvent{Event: &pb.InputEvent_MouseDown{MouseDown: event}})
+		case !isHeld && wasHeld:
+			event := &pb.MouseEvent{X: int32(px), Y: int32(py), Button: b.button,
+				ClickCount: int32(mouseState.clickCount), TabId: activeTabID()}
+			queueInput(&pb.InputEvent{Event: &pb.InputEvent_MouseUp{MouseUp: event}})
+		}
+	}
+	mouseState.buttons = held
+
+	// The wheel scrolls the page under the pointer
+	if inside {
+		var deltaX, deltaY int
+		if buttons&tcell.WheelUp != 0 {
+			deltaY -= WHEEL_SCROLL_DELTA
+		}
+		if buttons&tcell.WheelDown != 0 {
+			deltaY += WHEEL_SCROLL_DELTA
+		}
+		if buttons&tcell.WheelLeft != 0 {
+			deltaX -= WHEEL_SCROLL_DELTA
+		}
+		if buttons&tcell.WheelRight != 0 {
+			deltaX += WHEEL_SCROLL_DELTA
+		}
+		if deltaX != 0 || deltaY != 0 {
+			wheel := &pb.WheelEvent{X: int32(px), Y: int32(py), DeltaX: int32(deltaX), DeltaY: int32(deltaY),
+				TabId: activeTabID()}
+			queueInput(&pb.InputEvent{Event: &pb.InputEvent_Wheel{Wheel: wheel}})
+		}
+	}
+}
+
+// countClick returns the click count for a press of button at cell (x, y):
+// 2 when it quickly follows a press of the same button at the same spot, and so on
+func countClick(button tcell.ButtonMask, x, y int) int {
+	now := time.Now()
+	if button == mouseState.clickButton &&
+		now.Sub(mouseState.clickTime) <= DOUBLE_CLICK_INTERVAL &&
+		abs(x-mouseState.clickX) <= 1 && abs(y-mouseState.clickY) <= 1 {
+		mouseState.clickCount++
+	} else {
+		mouseState.clickCount = 1
+	}
+	mouseState.clickButton = button
+	mouseState.clickTime = now
+	mouseState.clickX, mouseState.clickY = x, y
+	return mouseState.clickCount
+}
+
+// insideBrowserPanel reports whether cell (x, y) shows part of the page
+func insideBrowserPanel(x, y int) bool {
+	return x >= H_BORDER_WIDTH && x < H_BORDER_WIDTH+sDims.InnerWidth &&
+		y >= V_BORDER_WIDTH && y < V_BORDER_WIDTH+sDims.InnerViewHeight
+}
+
+// clampToBrowserPanel keeps a pixel position inside the viewport, for drags that leave the panel
+func clampToBrowserPanel(px, py int) (int, int) {
+	px = max(0, min(px, sDims.InnerWidthPx-1))
+	py = max(0, min(py, sDims.InnerHeightPx-1))
+	return px, py
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	pb "termium/client/pb"
+)
+
+// drainSent drains the input queue the way the session sender does, describing each event sent
+func drainSent(t *testing.T) []string {
+	t.Helper()
+	var sent []string
+	for len(inputQueue) > 0 {
+		for _, msg := range inputMessages(<-inputQueue) {
+			switch event := msg.GetInput().GetEvent().(type) {
+			case *pb.InputEvent_MouseMove:
+				sent = append(sent, fmt.Sprintf("move %d,%d", event.MouseMove.X, event.MouseMove.Y))
+			case *pb.InputEvent_MouseDown:
+				sent = append(sent, fmt.Sprintf("down %d,%d", event.MouseDown.X, event.MouseDown.Y))
+			case *pb.InputEvent_MouseUp:
+				sent = append(sent, fmt.Sprintf("up %d,%d", event.MouseUp.X, event.MouseUp.Y))
+			default:
+				t.Fatalf("unexpected message %v", msg)
+			}
+		}
+	}
+	return sent
+}
+
+func TestMouseMovesMergeOnlyWhenAdjacent(t *testing.T) {
+	down := func(x, y int32) {
+		queueInput(&pb.InputEvent{Event: &pb.InputEvent_MouseDown{MouseDown: &pb.MouseEvent{X: x, Y: y}}})
+	}
+	up := func(x, y int32) {
+		queueInput(&pb.InputEvent{Event: &pb.InputEvent_MouseUp{MouseUp: &pb.MouseEvent{X: x, Y: y}}})
+	}
+
+	tests := []struct {
+		name  string
+		input func()
+		want  []string
+	}{
+		{"adjacent moves merge", func() {
+			queueMouseMove(1, 1)
+			queueMouseMove(2, 2)
+			queueMouseMove(3, 3)
+		}, []string{"move 3,3"}},
+		{"drag keeps its order", func() {
+			queueMouseMove(1, 1)
+			down(1, 1)
+			queueMouseMove(5, 5)
+			queueMouseMove(9, 9)
+			up(9, 9)
+		}, []string{"move 1,1", "down 1,1", "move 9,9", "up 9,9"}},
+		{"move after a sent move queues again", func() {
+			queueMouseMove(1, 1)
+			drainSent(t)
+			queueMouseMove(2, 2)
+		}, []string{"move 2,2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetFrameState(t)
+			closeMoveSlot()
+			tt.input()
+			if got := drainSent(t); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sent %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
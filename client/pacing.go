@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	pb "termium/client/pb"
+)
+
+const (
+	// Share of the time between frames rendering may take; the rest is left for input and the terminal
+	FRAME_PACING_HEADROOM = 0.8
+	// Weight of the newest render time in the average
+	FRAME_PACING_SMOOTHING = 0.2
+	// Shortest time between two rate changes, so the server isn't told a new rate every frame
+	FRAME_PACING_INTERVAL = time.Second
+	// A frame still rendering after this long means the terminal stopped reading, e.g. while its
+	// output is suspended. Frames pause until it is done.
+	FRAME_LAG_THRESHOLD = time.Second
+	// How long the display loop waits for a frame before checking whether it should stop
+	FRAME_WAIT_TIMEOUT = 100 * time.Millisecond
+	// Unchanged frames for this long mean the page is static; frames drop to FRAME_IDLE_FPS then
+	FRAME_IDLE_AFTER = 2 * time.Second
+	// Heartbeat rate while the page is static, enough to notice it changing on its own
+	FRAME_IDLE_FPS = 1
+)
+
+// How fast frames can be shown, and the rate the server was asked for accordingly
+var framePacing struct {
+	sync.Mutex
+	renderTime time.Duration // Average time to show a frame
+	fps        int           // Rate asked of the server, up to cfg.FPS
+	paused     bool          // Frames paused while the terminal lags
+	changedAt  time.Time
+	idle       bool      // Frames at FRAME_IDLE_FPS while the page is static
+	staticFrom time.Time // First of the current run of unchanged frames, zero if the last one changed
+}
+
+// Holds a value while the server was not told the latest rate yet. A single slot, so rate changes
+// never wait behind queued input and the server only hears the newest rate.
+var frameRateChanged = make(chan struct{}, 1)
+
+// sessionFrameRate returns the rate a new session starts frames at: the one the client settled on
+// in the last session, which ended any pause or idling
+func sessionFrameRate() int {
+	framePacing.Lock()
+	defer framePacing.Unlock()
+	framePacing.paused = false
+	framePacing.idle = false
+	framePacing.staticFrom = time.Time{}
+	if framePacing.fps == 0 {
+		framePacing.fps = cfg.FPS
+	}
+	return framePacing.fps
+}
+
+// pacedFrameRate returns the rate frames are currently asked for, 0 while paused
+func pacedFrameRate() int {
+	framePacing.Lock()
+	defer framePacing.Unlock()
+	if framePacing.paused {
+		return 0
+	}
+	if framePacing.idle {
+		return min(framePacing.fps, FRAME_IDLE_FPS)
+	}
+	return framePacing.fps
+}
+
+// frameUnchanged is called for a frame identical to the one before it. After FRAME_IDLE_AFTER of
+// them frames drop to the heartbeat rate, there is nothing to show at the full one.
+func frameUnchanged() {
+	framePacing.Lock()
+	now := time.Now()
+	if framePacing.staticFrom.IsZero() {
+		framePacing.staticFrom = now
+	}
+	idle := !framePacing.idle && now.Sub(framePacing.staticFrom) >= FRAME_IDLE_AFTER
+	if idle {
+		framePacing.idle = true
+	}
+	framePacing.Unlock()
+
+	if idle {
+		Debug(fmt.Sprintf("Page static for %v, dropping to %d FPS", FRAME_IDLE_AFTER, FRAME_IDLE_FPS), DEBUG)
+		sendFrameRate()
+	}
+}
+
+// frameActivity is called on anything that may change the page: a changed frame, input, or a page
+// event. Frames go back to the full rate if they were idling.
+func frameActivity() {
+	framePacing.Lock()
+	framePacing.staticFrom = time.Time{}
+	wake := framePacing.idle
+	framePacing.idle = false
+	framePacing.Unlock()
+
+	if wake {
+		Debug("Page activity, frames back to full rate", DEBUG)
+		sendFrameRate()
+	}
+}
+
+// paceFrame is called before a frame is shown and returns the function to call once it was.
+// It pauses frames while showing one takes too long, and adjusts the rate to the render time.
+func paceFrame() func() {
+	start := time.Now()
+	lag := time.AfterFunc(FRAME_LAG_THRESHOLD, func() {
+		framePacing.Lock()
+		framePacing.paused = true
+		framePacing.Unlock()
+		Debug(fmt.Sprintf("Terminal lagging, frame still rendering after %v; pausing frames", FRAME_LAG_THRESHOLD), WARN)
+		sendFrameRate()
+	})
+
+	return func() {
+		lag.Stop()
+		elapsed := time.Since(start)
+
+		framePacing.Lock()
+		if framePacing.renderTime == 0 {
+			framePacing.renderTime = elapsed
+		} else {
+			framePacing.renderTime += time.Duration(FRAME_PACING_SMOOTHING * float64(elapsed-framePacing.renderTime))
+		}
+		target := cfg.FPS
+		if framePacing.renderTime > 0 {
+			if achievable := int(FRAME_PACING_HEADROOM * float64(time.Second) / float64(framePacing.renderTime)); achievable < target {
+				target = max(achievable, 1)
+			}
+		}
+		resume := framePacing.paused
+		change := target != framePacing.fps && time.Since(framePacing.changedAt) >= FRAME_PACING_INTERVAL
+		if resume || change {
+			framePacing.paused = false
+			framePacing.fps = target
+			framePacing.changedAt = time.Now()
+		}
+		renderTime := framePacing.renderTime
+		framePacing.Unlock()
+
+		if resume || change {
+			Debug(fmt.Sprintf("Frames take %v to show, asking for %d FPS", renderTime.Round(time.Millisecond), target), INFO)
+			sendFrameRate()
+		}
+	}
+}
+
+// sendFrameRate has the session tell the server the rate to send frames at now. It never blocks,
+// it is called while frames are shown.
+func sendFrameRate() {
+	select {
+	case frameRateChanged <- struct{}{}:
+	default:
+		// Already pending; the rate is read when it is sent
+	}
+}
+
+// frameRateMessage returns the message with the rate frames are asked for. The rate is read when it
+// is sent, so a pause and the resume after it cannot arrive the wrong way round.
+func frameRateMessage() *pb.ClientMessage {
+	return &pb.ClientMessage{Message: &pb.ClientMessage_Control{Control: &pb.SessionControl{
+		Control: &pb.SessionControl_SetFps{SetFps: int32(pacedFrameRate())},
+	}}}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSendFrameRateCoalesces(t *testing.T) {
+	resetFrameState(t)
+	for len(frameRateChanged) > 0 {
+		<-frameRateChanged
+	}
+	// Fill the input queue, a rate change must not wait for room in it
+	for len(inputQueue) < cap(inputQueue) {
+		inputQueue <- queuedInput{}
+	}
+	t.Cleanup(func() { resetFrameState(t) })
+
+	framePacing.Lock()
+	framePacing.fps, framePacing.paused, framePacing.idle = 10, false, false
+	framePacing.Unlock()
+
+	start := time.Now()
+	sendFrameRate()
+	framePacing.Lock()
+	framePacing.paused = true
+	framePacing.Unlock()
+	sendFrameRate()
+	if elapsed := time.Since(start); elapsed > INPUT_QUEUE_TIMEOUT/2 {
+		t.Errorf("sendFrameRate took %v with the input queue full", elapsed)
+	}
+
+	if len(frameRateChanged) != 1 {
+		t.Fatalf("%d rate changes pending, want 1", len(frameRateChanged))
+	}
+	<-frameRateChanged
+	if fps := frameRateMessage().GetControl().GetSetFps(); fps != 0 {
+		t.Errorf("rate sent = %d, want the latest one, 0 while paused", fps)
+	}
+}
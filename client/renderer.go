@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Renderer draws browser frames into the browser panel.
+// Implementations own all of their protocol state, so they can be swapped at runtime.
+type Renderer interface {
+	// Name returns the name used by --renderer
+	Name() string
+	// Init prepares the renderer to draw on s; called when it becomes the active renderer
+	Init(s tcell.Screen) error
+	// DrawFrame draws img (already scaled to fit) at the top-left of the browser panel.
+	// dirty lists the parts of img that changed since the last frame, nil if unknown.
+	DrawFrame(s tcell.Screen, img *image.RGBA, dirty []image.Rectangle) error
+	// Resize is called with the new browser panel size in pixels
+	Resize(widthPx, heightPx int)
+	// Invalidate forces the next frame to be drawn in full, e.g. after tcell repainted the screen
+	Invalidate()
+	// Stats returns drawing statistics
+	Stats() RendererStats
+	// Close removes anything the renderer left on screen that tcell cannot clear by itself
+	Close()
+}
+
+// RendererStats holds per-renderer drawing statistics
+type RendererStats struct {
+	Frames    uint64        // Frames drawn
+	LastFrame time.Duration // Time spent drawing the last frame
+	TotalTime time.Duration // Time spent drawing all frames
+	BytesSent uint64        // Escape sequence bytes written to the terminal
+	Detail    string        // Renderer specific information, e.g. dirty band ratio
+}
+
+// record adds one drawn frame to the statistics
+func (rs *RendererStats) record(elapsed time.Duration, bytes int) {
+	rs.Frames++
+	rs.LastFrame = elapsed
+	rs.TotalTime += elapsed
+	rs.BytesSent += uint64(bytes)
+}
+
+// String formats the statistics for the timings output
+func (rs RendererStats) String() string {
+	var avg time.Duration
+	if rs.Frames > 0 {
+		avg = rs.TotalTime / time.Duration(rs.Frames)
+	}
+	stats := fmt.Sprintf("Frames=%d Last=%v Avg=%v Sent=%dKB", rs.Frames, rs.LastFrame, avg, rs.BytesSent/1024)
+	if rs.Detail != "" {
+		stats += " " + rs.Detail
+	}
+	return stats
+}
+
+// Available renderers, in the order the renderer switch key cycles through them
+var rendererNames = []string{"sixel", "kitty", "iterm2", "tcell"}
+
+// newRenderer creates a renderer by name
+func newRenderer(name string) (Renderer, error) {
+	switch name {
+	case "sixel":
+		return newSixelRenderer(), nil
+	case "kitty":
+		return newKittyRenderer(), nil
+	case "iterm2":
+		return newITermRenderer(), nil
+	case "tcell":
+		return newTcellRenderer(), nil
+	}
+	return nil, fmt.Errorf("unknown renderer: %s", name)
+}
+
+// The active renderer. rendererMutex is held while a frame is drawn so a switch never
+// interleaves the escape sequences of two protocols.
+var activeRenderer Renderer
+var rendererMutex sync.Mutex
+
+// setRenderer makes r the active renderer, cleaning up after the previous one
+func setRenderer(s tcell.Screen, r Renderer) error {
+	rendererMutex.Lock()
+	defer rendererMutex.Unlock()
+
+	if err := r.Init(s); err != nil {
+		return fmt.Errorf("failed to initialize %s renderer: %v", r.Name(), err)
+	}
+	r.Resize(sDims.InnerWidthPx, sDims.InnerHeightPx)
+
+	if activeRenderer != nil {
+		activeRenderer.Close()
+		clearBrowserPanel(s)
+	}
+	activeRenderer = r
+	activeRenderer.Invalidate()
+	lastKeyframeShown.Store(false)
+
+	Debug(fmt.Sprintf("Active renderer: %s", r.Name()), INFO)
+	return nil
+}
+
+// switchRenderer replaces the active renderer with the one called name and redraws the current frame
+func switchRenderer(s tcell.Screen, name string) error {
+	r, err := newRenderer(name)
+	if err != nil {
+		return err
+	}
+	if err := setRenderer(s, r); err != nil {
+		return err
+	}
+	s.Show()
+
+	// Redraw right away instead of waiting for the next frame from the server
+	if imageBuffer != nil {
+		if err := displayImageBuffer(s, nil); err != nil {
+			return err
+		}
+		s.Show()
+	}
+	return nil
+}
+
+// cycleRenderer switches to the next renderer in rendererNames
+func cycleRenderer(s tcell.Screen) {
+	next := rendererNames[0]
+	if current := currentRenderer(); current != nil {
+		for i, name := range rendererNames {
+			if name == current.Name() {
+				next = rendererNames[(i+1)%len(rendererNames)]
+				break
+			}
+		}
+	}
+
+	if err := switchRenderer(s, next); err != nil {
+		Debug(fmt.Sprintf("Failed to switch renderer: %v", err), ERROR)
+	} else {
+		logBuffer.Write([]byte(fmt.Sprintf("Switched to %s renderer", next)))
+	}
+	displayBottomPanel(s)
+	s.Show()
+}
+
+// currentRenderer returns the active renderer
+func currentRenderer() Renderer {
+	rendererMutex.Lock()
+	defer rendererMutex.Unlock()
+	return activeRenderer
+}
+
+// drawWithRenderer draws a frame with the active renderer
+func drawWithRenderer(s tcell.Screen, img *image.RGBA, dirty []image.Rectangle) error {
+	rendererMutex.Lock()
+	defer rendererMutex.Unlock()
+
+	if activeRenderer == nil {
+		return fmt.Errorf("no active renderer")
+	}
+	return activeRenderer.DrawFrame(s, img, dirty)
+}
+
+// invalidateRenderer makes the active renderer draw the next frame in full
+func invalidateRenderer() {
+	rendererMutex.Lock()
+	defer rendererMutex.Unlock()
+
+	if activeRenderer != nil {
+		activeRenderer.Invalidate()
+	}
+	// The next keyframe has to be drawn even if it is the one shown before
+	lastKeyframeShown.Store(false)
+}
+
+// resizeRenderer tells the active renderer about the new browser panel size
+func resizeRenderer() {
+	rendererMutex.Lock()
+	defer rendererMutex.Unlock()
+
+	if activeRenderer != nil {
+		activeRenderer.Resize(sDims.InnerWidthPx, sDims.InnerHeightPx)
+	}
+}
+
+// closeRenderer lets the active renderer clean up before the screen is finalized
+func closeRenderer() {
+	rendererMutex.Lock()
+	defer rendererMutex.Unlock()
+
+	if activeRenderer != nil {
+		activeRenderer.Close()
+	}
+}
+
+// clearBrowserPanel blanks every cell of the browser panel, e.g. block characters left by the tcell renderer
+func clearBrowserPanel(s tcell.Screen) {
+	for y := V_BORDER_WIDTH; y < sDims.LogPanelTop; y++ {
+		for x := H_BORDER_WIDTH; x < sDims.Width-H_BORDER_WIDTH; x++ {
+			s.SetContent(x, y, ' ', nil, tcell.StyleDefault)
+		}
+	}
+}
+
+// tcellRenderer draws frames with Unicode block characters for terminals without graphics support
+type tcellRenderer struct {
+	fullRedraw bool // Next frame must set every cell, not just the ones that changed
+	stats      RendererStats
+}
+
+func newTcellRenderer() *tcellRenderer {
+	return &tcellRenderer{fullRedraw: true}
+}
+
+func (r *tcellRenderer) Name() string { return "tcell" }
+
+func (r *tcellRenderer) Init(s tcell.Screen) error { return nil }
+
+func (r *tcellRenderer) DrawFrame(s tcell.Screen, img *image.RGBA, dirty []image.Rectangle) error {
+	start := time.Now()
+	if r.fullRedraw {
+		dirty = nil
+		r.fullRedraw = false
+	}
+	err := displayWithTcell(s, img, dirty)
+	r.stats.record(time.Since(start), 0)
+	return err
+}
+
+// The cells of the old layout or a cleared screen are gone, so the next frame sets them all again
+func (r *tcellRenderer) Resize(widthPx, heightPx int) {
+	r.fullRedraw = true
+}
+
+func (r *tcellRenderer) Invalidate() {
+	r.fullRedraw = true
+}
+
+func (r *tcellRenderer) Stats() RendererStats { return r.stats }
+
+func (r *tcellRenderer) Close() {}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"image"
+	"testing"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// fakeRenderer records what it is asked to draw instead of writing to a terminal
+type fakeRenderer struct {
+	name        string
+	drawn       []drawnFrame
+	invalidated int
+	closed      bool
+	width       int
+	height      int
+	err         error // Returned by DrawFrame when set
+}
+
+type drawnFrame struct {
+	size  image.Point
+	dirty []image.Rectangle
+}
+
+func (r *fakeRenderer) Name() string              { return r.name }
+func (r *fakeRenderer) Init(s tcell.Screen) error { return nil }
+
+func (r *fakeRenderer) DrawFrame(s tcell.Screen, img *image.RGBA, dirty []image.Rectangle) error {
+	r.drawn = append(r.drawn, drawnFrame{img.Bounds().Size(), dirty})
+	return r.err
+}
+
+func (r *fakeRenderer) Resize(widthPx, heightPx int) { r.width, r.height = widthPx, heightPx }
+func (r *fakeRenderer) Invalidate()                  { r.invalidated++ }
+func (r *fakeRenderer) Stats() RendererStats         { return RendererStats{Frames: uint64(len(r.drawn))} }
+func (r *fakeRenderer) Close()                       { r.closed = true }
+
+// testScreen sets up a simulated terminal with a browser panel of 78x18 cells of 10x20 pixels
+func testScreen(t *testing.T) tcell.Screen {
+	t.Helper()
+	s := tcell.NewSimulationScreen("UTF-8")
+	if err := s.Init(); err != nil {
+		t.Fatalf("failed to start simulation screen: %v", err)
+	}
+	s.SetSize(80, 24)
+	t.Cleanup(s.Fini)
+
+	charSize = CharSize{Width: 10, Height: 20}
+	sDims = ScreenDimensions{Width: 80, Height: 24, LogHeight: 5, LogPanelTop: 19, ViewHeight: 19,
+		InnerWidth: 78, InnerViewHeight: 18, InnerWidthPx: 780, InnerHeightPx: 360}
+	return s
+}
+
+// useFakeRenderer makes a fake the active renderer for the rest of the test
+func useFakeRenderer(t *testing.T, s tcell.Screen) *fakeRenderer {
+	t.Helper()
+	previous := activeRenderer
+	t.Cleanup(func() { activeRenderer = previous })
+	activeRenderer = nil
+
+	r := &fakeRenderer{name: "fake"}
+	if err := setRenderer(s, r); err != nil {
+		t.Fatalf("setRenderer: %v", err)
+	}
+	return r
+}
+
+func TestSetRendererReplacesActive(t *testing.T) {
+	s := testScreen(t)
+	first := useFakeRenderer(t, s)
+	second := &fakeRenderer{name: "second"}
+	if err := setRenderer(s, second); err != nil {
+		t.Fatalf("setRenderer: %v", err)
+	}
+
+	if !first.closed {
+		t.Error("previous renderer was not closed")
+	}
+	if currentRenderer() != second {
+		t.Errorf("active renderer is %v, want the second one", currentRenderer().Name())
+	}
+	if second.width != sDims.InnerWidthPx || second.height != sDims.InnerHeightPx {
+		t.Errorf("new renderer sized %dx%d, want %dx%d", second.width, second.height, sDims.InnerWidthPx, sDims.InnerHeightPx)
+	}
+	if second.invalidated != 1 {
+		t.Errorf("new renderer invalidated %d times, want 1", second.invalidated)
+	}
+}
+
+func TestDrawWithRendererUsesActive(t *testing.T) {
+	s := testScreen(t)
+	r := useFakeRenderer(t, s)
+
+	dirty := []image.Rectangle{image.Rect(0, 0, 4, 4)}
+	if err := drawWithRenderer(s, image.NewRGBA(image.Rect(0, 0, 8, 8)), dirty); err != nil {
+		t.Fatalf("drawWithRenderer: %v", err)
+	}
+	if len(r.drawn) != 1 || r.drawn[0].size != image.Pt(8, 8) || len(r.drawn[0].dirty) != 1 {
+		t.Errorf("drawn %+v, want one 8x8 frame with one dirty rect", r.drawn)
+	}
+
+	activeRenderer = nil
+	if err := drawWithRenderer(s, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil); err == nil {
+		t.Error("drawing without a renderer succeeded")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const DEFAULT_RPC_TIMEOUT = 10 * time.Second
+
+// How long each RPC may take before it is given up. Navigation waits for the page to load;
+// input only waits for Puppeteer to dispatch it.
+var rpcTimeouts = map[string]time.Duration{
+	"OpenTab":           10 * time.Second,
+	"ListTabs":          5 * time.Second,
+	"SwitchTab":         5 * time.Second,
+	"CloseTab":          10 * time.Second,
+	"SetViewport":       5 * time.Second,
+	"ClickMouse":        5 * time.Second,
+	"MouseMove":         2 * time.Second,
+	"MouseDown":         5 * time.Second,
+	"MouseUp":           5 * time.Second,
+	"MouseWheel":        5 * time.Second,
+	"SendKeyboardInput": 5 * time.Second,
+	"SendKeyEvent":      5 * time.Second,
+	"NavigateToUrl":     30 * time.Second,
+	"GetCurrentUrl":     5 * time.Second,
+	"GoBack":            30 * time.Second,
+	"GoForward":         30 * time.Second,
+	"Reload":            30 * time.Second,
+	"StopLoading":       5 * time.Second,
+}
+
+// Cancelled on shutdown, which ends every RPC still in flight
+var rpcShutdown, shutdownRPCs = context.WithCancel(context.Background())
+
+// rpcError is a failed RPC with a message fit for the log panel. status.FromError still finds the
+// gRPC status in it.
+type rpcError struct {
+	status  *status.Status
+	timeout time.Duration
+}
+
+func (e *rpcError) Error() string {
+	switch e.status.Code() {
+	case codes.Unavailable:
+		return "server unavailable, is it running?"
+	case codes.DeadlineExceeded:
+		return fmt.Sprintf("no answer from the server within %v", e.timeout)
+	case codes.Canceled:
+		return "cancelled"
+	case codes.Unimplemented:
+		return "not supported by this server version"
+	}
+	return fmt.Sprintf("%s (%s)", e.status.Message(), e.status.Code())
+}
+
+func (e *rpcError) GRPCStatus() *status.Status {
+	return e.status
+}
+
+// wrapRPCError turns a gRPC status error into an rpcError; other errors such as io.EOF are returned as they are
+func wrapRPCError(method string, timeout time.Duration, err error) error {
+	st, ok := status.FromError(err)
+	if err == nil || !ok {
+		return err
+	}
+	Debug(fmt.Sprintf("%s failed: %v", method, err), DEBUG)
+	return &rpcError{status: st, timeout: timeout}
+}
+
+// rpcTimeout returns the deadline for a full gRPC method name such as /pb.BrowserControl/OpenTab
+func rpcTimeout(method string) time.Duration {
+	if timeout, ok := rpcTimeouts[path.Base(method)]; ok {
+		return timeout
+	}
+	return DEFAULT_RPC_TIMEOUT
+}
+
+// withShutdown derives a context that is also cancelled on shutdown
+func withShutdown(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(rpcShutdown, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// unaryRPCInterceptor applies the method's deadline and shutdown cancellation to every unary RPC.
+// A shorter deadline set by the caller still wins.
+func unaryRPCInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	timeout := rpcTimeout(method)
+	ctx, cancel := withShutdown(ctx)
+	defer cancel()
+	ctx, cancelTimeout := context.WithTimeout(ctx, timeout)
+	defer cancelTimeout()
+
+	return wrapRPCError(path.Base(method), timeout, invoker(ctx, method, req, reply, cc, opts...))
+}
+
+// streamRPCInterceptor cancels streams on shutdown. Streams run for as long as the connection
+// does, so they get no deadline.
+func streamRPCInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+	streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx, cancel := withShutdown(ctx)
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		cancel()
+		return nil, wrapRPCError(path.Base(method), 0, err)
+	}
+	return &shutdownStream{ClientStream: stream, method: path.Base(method), cancel: cancel}, nil
+}
+
+// shutdownStream releases its context once the stream ends
+type shutdownStream struct {
+	grpc.ClientStream
+	method string
+	cancel context.CancelFunc
+}
+
+func (s *shutdownStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.cancel()
+	}
+	return wrapRPCError(s.method, 0, err)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	pb "termium/client/pb"
+)
+
+const (
+	// Input messages awaiting the frame that shows them. Past this the oldest are forgotten, a
+	// server that sends no frames would grow the list forever otherwise.
+	LATENCY_MAX_PENDING = 256
+	// Weight of the newest sample in the average input latency
+	LATENCY_SMOOTHING = 0.2
+)
+
+// Time from sending input to showing the first frame that includes it
+var inputLatency struct {
+	sync.Mutex
+	pending []sentInput // In the order sent
+	average time.Duration
+	samples int
+}
+
+type sentInput struct {
+	seq  uint64
+	sent time.Time
+}
+
+// resetInputLatency forgets the input of the last session, its sequence numbers start over
+func resetInputLatency() {
+	inputLatency.Lock()
+	defer inputLatency.Unlock()
+	inputLatency.pending = inputLatency.pending[:0]
+}
+
+// inputSent records when the input message seq was sent
+func inputSent(seq uint64) {
+	inputLatency.Lock()
+	defer inputLatency.Unlock()
+	if len(inputLatency.pending) == LATENCY_MAX_PENDING {
+		inputLatency.pending = inputLatency.pending[1:]
+	}
+	inputLatency.pending = append(inputLatency.pending, sentInput{seq, time.Now()})
+}
+
+// frameShown takes a latency sample from a frame taken after the server handled message inputSeq.
+// The oldest input it shows waited the longest, so that is the one measured.
+func frameShown(inputSeq uint64) {
+	inputLatency.Lock()
+	defer inputLatency.Unlock()
+	shown := 0
+	for shown < len(inputLatency.pending) && inputLatency.pending[shown].seq <= inputSeq {
+		shown++
+	}
+	if shown == 0 {
+		return
+	}
+
+	sample := time.Since(inputLatency.pending[0].sent)
+	inputLatency.pending = inputLatency.pending[shown:]
+	if inputLatency.samples == 0 {
+		inputLatency.average = sample
+	} else {
+		inputLatency.average += time.Duration(LATENCY_SMOOTHING * float64(sample-inputLatency.average))
+	}
+	inputLatency.samples++
+	Debug(fmt.Sprintf("Input latency %v (average %v)", sample.Round(time.Millisecond),
+		inputLatency.average.Round(time.Millisecond)), DEBUG)
+}
+
+// averageInputLatency returns the smoothed input latency, 0 before the first sample
+func averageInputLatency() time.Duration {
+	inputLatency.Lock()
+	defer inputLatency.Unlock()
+	return inputLatency.average
+}
+
+// Set while a keyframe is on its way, so a run of missed delta frames asks for only one
+var keyframeRequested atomic.Bool
+
+// requestKeyframe asks the server for a whole frame, the missed delta frames can't be made up for
+func requestKeyframe() {
+	if keyframeRequested.Swap(true) {
+		return
+	}
+	Debug("Missed a delta frame, requesting a keyframe", DEBUG)
+	if !enqueueInput(queuedInput{control: &pb.SessionControl{
+		Control: &pb.SessionControl_RequestKeyframe{RequestKeyframe: &pb.Empty{}},
+	}}) {
+		keyframeRequested.Store(false)
+	}
+}
+
+// runSession keeps a session stream open to the server for as long as the client runs. Input and
+// viewport changes go up it in order, frames and page events come down. It is reopened whenever
+// the connection comes back.
+func runSession(s tcell.Screen, frames *FrameBuffer) {
+	for {
+		generation, up, changed := connectionWatch()
+		if up {
+			err := session(s, frames)
+			if rpcShutdown.Err() != nil {
+				return
+			}
+			Debug(fmt.Sprintf("Session ended: %v", err), ERROR)
+			connectionLost(generation, err)
+		}
+		if !dropInputUntil(changed) {
+			return
+		}
+	}
+}
+
+// dropInputUntil discards queued input until changed is closed, there is no stream to send it on.
+// It returns false on shutdown.
+func dropInputUntil(changed <-chan struct{}) bool {
+	for {
+		select {
+		case item := <-inputQueue:
+			if item.move != nil {
+				takePendingMove(item.move)
+			}
+			Debug("Not connected, dropped an input event", DEBUG)
+		case <-changed:
+			return true
+		case <-rpcShutdown.Done():
+			return false
+		}
+	}
+}
+
+// session runs one session stream until it fails
+func session(s tcell.Screen, frames *FrameBuffer) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := grpcClient.Session(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start session: %v", err)
+	}
+	resetInputLatency()
+
+	received := make(chan error, 1)
+	go func() {
+		received <- receiveSession(s, stream, frames)
+	}()
+
+	var seq uint64
+	send := func(msg *pb.ClientMessage) error {
+		seq++
+		msg.Seq = seq
+		if _, ok := msg.Message.(*pb.ClientMessage_Input); ok {
+			inputSent(seq)
+		}
+		err := stream.Send(msg)
+		if err == io.EOF {
+			// The stream is gone, the receiver has the reason
+			return <-received
+		}
+		return err
+	}
+
+	err = send(&pb.ClientMessage{Message: &pb.ClientMessage_Control{Control: &pb.SessionControl{
+		Control: &pb.SessionControl_StartFrames{StartFrames: &pb.ScreenshotRequest{
+			Fps:        int32(sessionFrameRate()),
+			Format:     frameFormats[cfg.FrameFormat],
+			Deltas:     true,
+			Screencast: cfg.Screencast,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case item := <-inputQueue:
+			for _, msg := range inputMessages(item) {
+				if err := send(msg); err != nil {
+					return err
+				}
+			}
+		case <-frameRateChanged:
+			if err := send(frameRateMessage()); err != nil {
+				return err
+			}
+		case err := <-received:
+			return err
+		}
+	}
+}
+
+// receiveSession handles what the server sends on the session stream until it ends
+func receiveSession(s tcell.Screen, stream pb.BrowserControl_SessionClient, frames *FrameBuffer) error {
+	var lastSeq uint64
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if lastSeq != 0 && msg.Seq != lastSeq+1 {
+			Debug(fmt.Sprintf("Session messages out of sequence: %d after %d", msg.Seq, lastSeq), WARN)
+		}
+		lastSeq = msg.Seq
+
+		switch m := msg.Message.(type) {
+		case *pb.ServerMessage_Frame:
+			// Write to the current write frame and swap to make it ready for display
+			frame := frames.GetWriteFrame()
+			frame.Data = m.Frame.Data
+			frame.Format = m.Frame.Format
+			frame.Width, frame.Height = int(m.Frame.Width), int(m.Frame.Height)
+			frame.Stride = int(m.Frame.Stride)
+			frame.InputSeq = m.Frame.InputSeq
+			frame.Seq, frame.Keyframe, frame.Rects = m.Frame.Seq, m.Frame.Keyframe, m.Frame.Rects
+			frame.Metadata = m.Frame.Metadata
+			frame.Timestamp = time.Now()
+			frames.SwapWriteFrame()
+		case *pb.ServerMessage_PageEvent:
+			handlePageEvent(s, m.PageEvent)
+		case *pb.ServerMessage_Ack:
+			if m.Ack.Error != "" {
+				Debug(fmt.Sprintf("Server failed to handle message %d: %s", m.Ack.Seq, m.Ack.Error), ERROR)
+			}
+		}
+	}
+}
@@ -2,194 +2,242 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"image"
 	"image/color"
 	"image/color/palette"
-	"image/draw"
 	"io"
-	
-	"github.com/mattn/go-sixel"
+	"sort"
+)
+
+const (
+	SIXEL_MAX_COLORS = 256 // Color registers available to sixel images
+	COLOR_CACHE_BITS = 6   // Bits per channel used to key the palette lookup cache
 )
 
-// BandEncoder handles sixel encoding for individual bands
+// BandEncoder handles sixel encoding for individual bands.
+// All bands are encoded against one fixed palette, so a band encoded in an earlier
+// frame can be reused as-is as long as its pixels have not changed.
 type BandEncoder struct {
-	encoder       *sixel.Encoder
-	palette       color.Palette
-	paletteType   sixel.PaletteType
-	width         int
-	height        int
-	buffer        *bytes.Buffer
-	normalizedImg *image.RGBA // Reusable buffer for normalized band images
+	palette     color.Palette
+	paletteType string
+	width       int
+	height      int
+	buffer      *bytes.Buffer
+	colorBits   [][]byte // Per color: the 6-bit sixel pattern of every column
+	usedColors  []int    // Colors present in the band being encoded
+	colorSeen   []bool   // Whether a color is already in usedColors
+	colorCache  []int16  // Quantized RGB -> palette index, -1 when not looked up yet
 }
 
-// NewBandEncoder creates a new band encoder
-func NewBandEncoder(paletteType sixel.PaletteType, width, height int) *BandEncoder {
+// NewBandEncoder creates a new band encoder.
+// Fixed palettes (websafe, plan9) are ready immediately; the adaptive palette is
+// built from the first frame with SetPalette.
+func NewBandEncoder(paletteType string, width, height int) *BandEncoder {
 	// Pre-allocate buffer with reasonable capacity (estimate ~4 bytes per pixel)
 	buf := &bytes.Buffer{}
 	buf.Grow(width * SIXEL_BAND_HEIGHT * 4)
-	
-	encoder := sixel.NewEncoder(buf)
-	encoder.Dither = false
-	encoder.Palette = paletteType
-	
-	var pal color.Palette
+
+	be := &BandEncoder{
+		paletteType: paletteType,
+		width:       width,
+		height:      height,
+		buffer:      buf,
+		colorBits:   make([][]byte, SIXEL_MAX_COLORS),
+		usedColors:  make([]int, 0, SIXEL_MAX_COLORS),
+		colorSeen:   make([]bool, SIXEL_MAX_COLORS),
+		colorCache:  make([]int16, 1<<(3*COLOR_CACHE_BITS)),
+	}
+	for i := range be.colorBits {
+		be.colorBits[i] = make([]byte, width)
+	}
+
 	switch paletteType {
-	case sixel.PaletteWebSafe:
-		pal = palette.WebSafe
-	case sixel.PalettePlan9:
-		pal = palette.Plan9
+	case "websafe":
+		be.SetPalette(palette.WebSafe)
+	case "plan9":
+		be.SetPalette(palette.Plan9)
 	default:
-		// For adaptive, we'll need to handle this differently
-		pal = nil
+		// Adaptive: the palette is derived from the first frame
+		be.resetColorCache()
 	}
-	
-	// Pre-allocate normalized image buffer for maximum band height
-	normalizedImg := image.NewRGBA(image.Rect(0, 0, width, SIXEL_BAND_HEIGHT))
-	
-	return &BandEncoder{
-		encoder:       encoder,
-		palette:       pal,
-		paletteType:   paletteType,
-		width:         width,
-		height:        height,
-		buffer:        buf,
-		normalizedImg: normalizedImg,
+
+	return be
+}
+
+// Palette returns the palette bands are encoded against (nil until one is set)
+func (be *BandEncoder) Palette() color.Palette {
+	return be.palette
+}
+
+// IsAdaptive reports whether the palette is derived from frame content
+func (be *BandEncoder) IsAdaptive() bool {
+	return be.paletteType != "websafe" && be.paletteType != "plan9"
+}
+
+// SetPalette replaces the palette. Every cached band becomes invalid.
+func (be *BandEncoder) SetPalette(pal color.Palette) {
+	if len(pal) > SIXEL_MAX_COLORS {
+		pal = pal[:SIXEL_MAX_COLORS]
+	}
+	be.palette = pal
+	be.resetColorCache()
+}
+
+// resetColorCache forgets all cached palette lookups
+func (be *BandEncoder) resetColorCache() {
+	for i := range be.colorCache {
+		be.colorCache[i] = -1
 	}
 }
 
-// EncodeBand encodes a single band to sixel format
+// paletteIndex maps a pixel to its palette index, caching palette.Index results
+// since it is by far the most expensive part of encoding
+func (be *BandEncoder) paletteIndex(r, g, b uint8) uint8 {
+	const shift = 8 - COLOR_CACHE_BITS
+	key := int(r>>shift)<<(2*COLOR_CACHE_BITS) | int(g>>shift)<<COLOR_CACHE_BITS | int(b>>shift)
+	if idx := be.colorCache[key]; idx >= 0 {
+		return uint8(idx)
+	}
+	idx := be.palette.Index(color.RGBA{r, g, b, 0xff})
+	be.colorCache[key] = int16(idx)
+	return uint8(idx)
+}
+
+// EncodeBand encodes a single band to sixel pixel data (no header, no palette).
+// Bands are joined with a Graphics New Line by ComposeFullSixel.
 func (be *BandEncoder) EncodeBand(img *image.RGBA, bandY int, bandHeight int) (string, error) {
 	// Clear the buffer
 	be.buffer.Reset()
-	
-	// Create a sub-image for just this band
-	bandRect := image.Rect(0, bandY, be.width, bandY+bandHeight)
-	bandSubImg := img.SubImage(bandRect).(*image.RGBA)
-	
-	// Reuse the pre-allocated normalized image buffer
-	// Adjust bounds if band height is different (e.g., last band)
-	normalizedRect := image.Rect(0, 0, be.width, bandHeight)
-	if normalizedRect != be.normalizedImg.Bounds() {
-		// Only reallocate if dimensions changed (rare - only for last band)
-		be.normalizedImg = image.NewRGBA(normalizedRect)
+
+	if be.palette == nil {
+		return "", fmt.Errorf("no palette set for band encoder")
 	}
-	draw.Draw(be.normalizedImg, normalizedRect, bandSubImg, bandSubImg.Bounds().Min, draw.Src)
-	
-	// Temporarily set the encoder dimensions to just this band
-	be.encoder.Width = be.width
-	be.encoder.Height = bandHeight
-	
-	// Encode the normalized band image
-	if err := be.encoder.Encode(be.normalizedImg.SubImage(normalizedRect)); err != nil {
-		return "", err
+
+	bounds := img.Bounds()
+	width := be.width
+	if width > bounds.Dx() {
+		width = bounds.Dx()
 	}
-	
-	// Get the encoded string
-	encoded := be.buffer.String()
-	
-	// Strip the sixel header/footer since we'll compose them ourselves
-	// Sixel format: ESC P ... ESC \
-	// We want just the middle part for bands
-	stripped := stripSixelWrapper(encoded)
-	
-	return stripped, nil
-}
 
-// stripSixelWrapper removes the sixel introducer and terminator
-// and extracts ONLY the pixel data (no header, no dimensions, no palette)
-func stripSixelWrapper(sixelStr string) string {
-	// Sixel format: ESC P params q "dimensions" #palette_entries pixel_data ESC \
-	// We want ONLY the pixel data part
-	
-	// Strategy: Find where palette definitions end and pixel data begins
-	// Palette entries look like: #N;2;R;G;B
-	// Pixel data starts with color selections like #N followed by pixel chars
-	
-	lastPaletteEnd := -1
-	
-	// Find all palette entries (they have ;2; after the number)
-	for i := 0; i < len(sixelStr)-7; i++ {
-		if sixelStr[i] == '#' {
-			j := i + 1
-			// Skip the number
-			numStart := j
-			for j < len(sixelStr) && sixelStr[j] >= '0' && sixelStr[j] <= '9' {
-				j++
-			}
-			// Check if this is a palette definition
-			if j > numStart && j+2 < len(sixelStr) && sixelStr[j:j+3] == ";2;" {
-				// This is a palette entry, find where it ends
-				j += 3 // Skip ;2;
-				// Skip R value
-				for j < len(sixelStr) && sixelStr[j] != ';' {
-					j++
-				}
-				if j < len(sixelStr) {
-					j++ // Skip semicolon
-					// Skip G value
-					for j < len(sixelStr) && sixelStr[j] != ';' {
-						j++
-					}
-					if j < len(sixelStr) {
-						j++ // Skip semicolon
-						// Skip B value
-						for j < len(sixelStr) && sixelStr[j] >= '0' && sixelStr[j] <= '9' {
-							j++
-						}
-						// j now points to first char after this palette entry
-						lastPaletteEnd = j
-					}
-				}
+	// Map every pixel to its palette index and build the sixel pattern of each column per color
+	be.usedColors = be.usedColors[:0]
+	for row := 0; row < bandHeight && bandY+row < bounds.Dy(); row++ {
+		offset := img.PixOffset(bounds.Min.X, bounds.Min.Y+bandY+row)
+		bit := byte(1) << row
+		for x := 0; x < width; x++ {
+			p := img.Pix[offset : offset+3 : offset+3]
+			idx := be.paletteIndex(p[0], p[1], p[2])
+			if !be.colorSeen[idx] {
+				be.colorSeen[idx] = true
+				be.usedColors = append(be.usedColors, int(idx))
 			}
+			be.colorBits[idx][x] |= bit
+			offset += 4
 		}
 	}
-	
-	pixelStart := -1
-	if lastPaletteEnd != -1 {
-		pixelStart = lastPaletteEnd
-	} else {
-		// Fallback: look for pixel data after 'q' and optional dimension spec
-		for i := 0; i < len(sixelStr)-1; i++ {
-			if sixelStr[i] == 'q' {
-				j := i + 1
-				// Skip optional dimension spec like "1;1;896;900
-				if j < len(sixelStr) && sixelStr[j] == '"' {
-					// Skip until we're past the dimension spec
-					for j < len(sixelStr) && sixelStr[j] != '#' && sixelStr[j] != '$' && !(sixelStr[j] >= '?' && sixelStr[j] <= '~') {
-						j++
-					}
-				}
-				// If we hit a #, there are palette entries to skip
-				if j < len(sixelStr) && sixelStr[j] == '#' {
-					// Already handled above, this shouldn't happen
-					continue
-				}
-				// Otherwise we should be at pixel data
-				if j < len(sixelStr) && (sixelStr[j] == '$' || (sixelStr[j] >= '?' && sixelStr[j] <= '~')) {
-					pixelStart = j
-					break
-				}
+
+	// Emit one pass per color, returning to the start of the band in between
+	sort.Ints(be.usedColors)
+	for n, idx := range be.usedColors {
+		if n > 0 {
+			// DECGCR - Graphics Carriage Return
+			be.buffer.WriteByte('$')
+		}
+		be.buffer.WriteByte('#')
+		be.buffer.Write(intToBytes(idx + 1))
+		writeSixelRun(be.buffer, be.colorBits[idx][:width])
+
+		// Clear the pattern so the slice can be reused for the next band
+		bits := be.colorBits[idx]
+		for x := range bits {
+			bits[x] = 0
+		}
+		be.colorSeen[idx] = false
+	}
+
+	return be.buffer.String(), nil
+}
+
+// writeSixelRun writes sixel patterns with run-length encoding, dropping trailing empty columns
+func writeSixelRun(buf *bytes.Buffer, bits []byte) {
+	end := len(bits)
+	for end > 0 && bits[end-1] == 0 {
+		end--
+	}
+
+	for x := 0; x < end; {
+		run := 1
+		for x+run < end && bits[x+run] == bits[x] {
+			run++
+		}
+		ch := bits[x] + '?'
+		if run > 3 {
+			// DECGRI - Graphics Repeat Introducer
+			buf.WriteByte('!')
+			buf.Write(intToBytes(run))
+			buf.WriteByte(ch)
+		} else {
+			for i := 0; i < run; i++ {
+				buf.WriteByte(ch)
 			}
 		}
+		x += run
 	}
-	
-	if pixelStart == -1 {
-		return ""
+}
+
+// buildAdaptivePalette picks up to maxColors colors for img by popularity.
+// Colors are bucketed at 4 bits per channel and each palette entry is the average of its bucket,
+// which works well for web content where a handful of flat colors dominate.
+func buildAdaptivePalette(img *image.RGBA, maxColors int) color.Palette {
+	type bucket struct {
+		count   int
+		r, g, b int
 	}
-	
-	// Find end (before ESC \)
-	endIdx := len(sixelStr)
-	for i := len(sixelStr) - 2; i >= 0; i-- {
-		if sixelStr[i] == 0x1b && sixelStr[i+1] == '\\' {
-			endIdx = i
-			break
+	buckets := make([]bucket, 1<<12)
+
+	bounds := img.Bounds()
+	for y := 0; y < bounds.Dy(); y++ {
+		offset := img.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+		for x := 0; x < bounds.Dx(); x++ {
+			r, g, b := img.Pix[offset], img.Pix[offset+1], img.Pix[offset+2]
+			bk := &buckets[int(r>>4)<<8|int(g>>4)<<4|int(b>>4)]
+			bk.count++
+			bk.r += int(r)
+			bk.g += int(g)
+			bk.b += int(b)
+			offset += 4
 		}
 	}
-	
-	if pixelStart < endIdx {
-		return sixelStr[pixelStart:endIdx]
+
+	used := make([]int, 0, len(buckets))
+	for i := range buckets {
+		if buckets[i].count > 0 {
+			used = append(used, i)
+		}
+	}
+	sort.Slice(used, func(i, j int) bool {
+		return buckets[used[i]].count > buckets[used[j]].count
+	})
+	if len(used) > maxColors {
+		used = used[:maxColors]
+	}
+
+	pal := make(color.Palette, 0, len(used))
+	for _, i := range used {
+		bk := buckets[i]
+		pal = append(pal, color.RGBA{
+			R: uint8(bk.r / bk.count),
+			G: uint8(bk.g / bk.count),
+			B: uint8(bk.b / bk.count),
+			A: 0xff,
+		})
 	}
-	return ""
+	if len(pal) == 0 {
+		pal = append(pal, color.RGBA{A: 0xff})
+	}
+
+	return pal
 }
 
 // ComposeFullSixel creates a complete sixel image from band strings
@@ -219,12 +267,8 @@ func ComposeFullSixel(bands []string, width, height int, pal color.Palette) stri
 	
 	// Write each band's pixel data
 	for i, bandStr := range bands {
-		if bandStr == "" {
-			// Skip empty bands (shouldn't happen but be safe)
-			continue
-		}
-		
 		// Add Graphics New Line between bands to move down 6 pixels
+		// (even around an empty band, or everything below it would shift up)
 		if i > 0 {
 			// DECGNL - Graphics Next Line (moves cursor down 6 pixels)
 			buf.WriteByte('-')
@@ -93,16 +93,17 @@ func HashBand(img *image.RGBA, y, height, width int) uint32 {
 	return crc
 }
 
-// DetectDirtyBands compares the new frame with cached band hashes
-func (bm *BandManager) DetectDirtyBands(newFrame *image.RGBA) {
+// DetectDirtyBands compares the new frame with cached band hashes.
+// With the changed parts of the frame known, only the bands they touch are hashed.
+func (bm *BandManager) DetectDirtyBands(newFrame *image.RGBA, changed []image.Rectangle) {
 	for i := range bm.Bands {
 		band := &bm.Bands[i]
-		
-		// Compute hash for this band in the new frame
-		newHash := HashBand(newFrame, band.Y, band.Height, bm.Width)
-		
-		// Check if band has changed
-		if newHash != band.Hash {
+
+		// Bands never encoded have no hash to keep, so they are checked regardless
+		if changed != nil && band.CachedRLE != "" && !bandTouched(band, changed) {
+			band.IsDirty = false
+		} else if newHash := HashBand(newFrame, band.Y, band.Height, bm.Width); newHash != band.Hash {
+			// Compute hash for this band in the new frame and check if it has changed
 			band.IsDirty = true
 			band.Hash = newHash
 		} else {
@@ -119,6 +120,16 @@ func (bm *BandManager) DetectDirtyBands(newFrame *image.RGBA) {
 	bm.FrameNumber++
 }
 
+// bandTouched reports whether any of the rects overlaps the band
+func bandTouched(band *SixelBand, rects []image.Rectangle) bool {
+	for _, r := range rects {
+		if r.Min.Y < band.Y+band.Height && r.Max.Y > band.Y {
+			return true
+		}
+	}
+	return false
+}
+
 // GetDirtyBandCount returns the number of dirty bands
 func (bm *BandManager) GetDirtyBandCount() int {
 	count := 0
@@ -155,6 +166,47 @@ func (bm *BandManager) ComposeSixelOutput() string {
 	return output.String()
 }
 
+// BandRegion is a run of consecutive bands that is sent to the terminal as one sixel image
+type BandRegion struct {
+	FirstBand int // Index of the first band in the region
+	EndBand   int // Index one past the last band in the region
+	Y         int // Starting Y coordinate
+	Height    int // Height in pixels
+}
+
+// DirtyRegions groups dirty bands into regions whose top edge is a multiple of alignment pixels.
+// alignment must itself be a multiple of SIXEL_BAND_HEIGHT, so regions always start on a band boundary.
+func (bm *BandManager) DirtyRegions(alignment int) []BandRegion {
+	var regions []BandRegion
+	bandsPerUnit := alignment / SIXEL_BAND_HEIGHT
+	if bandsPerUnit < 1 {
+		bandsPerUnit = 1
+	}
+
+	for i := 0; i < bm.NumBands; i++ {
+		if !bm.Bands[i].IsDirty {
+			continue
+		}
+
+		// Pull the start up to the alignment boundary, merging with the previous region if they touch
+		first := (i / bandsPerUnit) * bandsPerUnit
+		if n := len(regions); n > 0 && first <= regions[n-1].EndBand {
+			regions[n-1].EndBand = i + 1
+		} else {
+			regions = append(regions, BandRegion{FirstBand: first, EndBand: i + 1})
+		}
+	}
+
+	for i := range regions {
+		region := &regions[i]
+		last := bm.Bands[region.EndBand-1]
+		region.Y = bm.Bands[region.FirstBand].Y
+		region.Height = last.Y + last.Height - region.Y
+	}
+
+	return regions
+}
+
 // MarkAllDirty forces all bands to be re-encoded
 func (bm *BandManager) MarkAllDirty() {
 	for i := range bm.Bands {
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestDirtyRegions(t *testing.T) {
+	tests := []struct {
+		name      string
+		height    int
+		dirty     []int
+		alignment int
+		want      []BandRegion
+	}{
+		{"nothing dirty", 60, nil, 6, nil},
+		{"one band", 60, []int{3}, 6, []BandRegion{{3, 4, 18, 6}}},
+		{"separate bands", 60, []int{1, 7}, 6, []BandRegion{{1, 2, 6, 6}, {7, 8, 42, 6}}},
+		{"neighbours merge", 60, []int{4, 5}, 6, []BandRegion{{4, 6, 24, 12}}},
+		{"start pulled up to alignment", 60, []int{3}, 12, []BandRegion{{2, 4, 12, 12}}},
+		{"aligned start merges with region before", 60, []int{3, 5}, 12, []BandRegion{{2, 6, 12, 24}}},
+		{"alignment below a band", 60, []int{3}, 2, []BandRegion{{3, 4, 18, 6}}},
+		{"short last band", 58, []int{9}, 6, []BandRegion{{9, 10, 54, 4}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bm := NewBandManager(8, tt.height)
+			for i := range bm.Bands {
+				bm.Bands[i].IsDirty = false
+			}
+			for _, i := range tt.dirty {
+				bm.Bands[i].IsDirty = true
+			}
+			if got := bm.DirtyRegions(tt.alignment); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DirtyRegions(%d) = %v, want %v", tt.alignment, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectDirtyBands(t *testing.T) {
+	tests := []struct {
+		name        string
+		changedRows []int             // Rows whose pixels change
+		changed     []image.Rectangle // Rects passed as changed, nil if unknown
+		encoded     bool              // Bands have a cached encoding
+		frameNumber uint64
+		want        []bool
+	}{
+		{"unchanged", nil, nil, true, 0, []bool{false, false, false, false}},
+		{"hashes find changed band", []int{13}, nil, true, 0, []bool{false, false, true, false}},
+		{"changed rects limit hashing", []int{1, 13}, []image.Rectangle{image.Rect(0, 12, 8, 18)}, true, 0,
+			[]bool{false, false, true, false}},
+		{"rect spanning two bands", []int{5, 6}, []image.Rectangle{image.Rect(0, 5, 8, 7)}, true, 0,
+			[]bool{true, true, false, false}},
+		{"no changes but rects", nil, []image.Rectangle{}, true, 0, []bool{false, false, false, false}},
+		{"bands never encoded are hashed", []int{1}, []image.Rectangle{}, false, 0,
+			[]bool{true, false, false, false}},
+		{"rolling refresh", nil, nil, true, 3, []bool{false, false, false, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewRGBA(image.Rect(0, 0, 8, 24))
+			bm := NewBandManager(8, 24)
+			bm.DetectDirtyBands(img, nil)
+			for i := range bm.Bands {
+				if tt.encoded {
+					bm.Bands[i].CachedRLE = "#0"
+				}
+			}
+			for _, y := range tt.changedRows {
+				img.Pix[img.PixOffset(0, y)] = 0xff
+			}
+
+			bm.FrameNumber = tt.frameNumber
+			bm.DetectDirtyBands(img, tt.changed)
+			got := make([]bool, len(bm.Bands))
+			for i, band := range bm.Bands {
+				got[i] = band.IsDirty
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("dirty bands = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"os"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// Above this fraction of dirty rows a single full-frame image is sent instead of partial ones
+const SIXEL_FULL_FRAME_RATIO = 0.5
+
+// Number of colors in the adaptive palette, lowered when the terminal has fewer color registers
+var sixelPaletteSize = SIXEL_MAX_COLORS
+
+// sixelRenderer draws frames as sixel images.
+// Bands are hashed every frame and only the dirty ones re-encoded.
+type sixelRenderer struct {
+	bands      *BandManager
+	encoder    *BandEncoder
+	fullRedraw bool // Next frame must be sent whole, not just the dirty regions
+	stats      RendererStats
+}
+
+func newSixelRenderer() *sixelRenderer {
+	return &sixelRenderer{fullRedraw: true}
+}
+
+func (r *sixelRenderer) Name() string { return "sixel" }
+
+func (r *sixelRenderer) Init(s tcell.Screen) error { return nil }
+
+// Band layout follows the frame size, which DrawFrame already tracks
+func (r *sixelRenderer) Resize(widthPx, heightPx int) {
+	r.fullRedraw = true
+}
+
+// Invalidate makes the next frame go out as one full image.
+// Needed whenever tcell repaints the browser panel, which wipes the sixel pixels on screen.
+func (r *sixelRenderer) Invalidate() {
+	r.fullRedraw = true
+}
+
+func (r *sixelRenderer) Stats() RendererStats { return r.stats }
+
+// Sixel pixels live in the text cells, so tcell clears them on its own
+func (r *sixelRenderer) Close() {}
+
+// DrawFrame encodes the frame as sixel, re-encoding only the bands that changed since the last frame
+// and sending only the regions that contain them
+func (r *sixelRenderer) DrawFrame(s tcell.Screen, img *image.RGBA, dirty []image.Rectangle) error {
+	sixelStart := time.Now()
+
+	buf := bufio.NewWriter(os.Stdout)
+	defer buf.Flush() // Ensures all data is written before function returns
+
+	// Terminals ignore anything beyond their maximum sixel geometry, so don't bother encoding it
+	if (termCaps.SixelMaxWidth > 0 && img.Bounds().Dx() > termCaps.SixelMaxWidth) ||
+		(termCaps.SixelMaxHeight > 0 && img.Bounds().Dy() > termCaps.SixelMaxHeight) {
+		clip := img.Bounds()
+		if termCaps.SixelMaxWidth > 0 {
+			clip.Max.X = min(clip.Max.X, clip.Min.X+termCaps.SixelMaxWidth)
+		}
+		if termCaps.SixelMaxHeight > 0 {
+			clip.Max.Y = min(clip.Max.Y, clip.Min.Y+termCaps.SixelMaxHeight)
+		}
+		img = img.SubImage(clip).(*image.RGBA)
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() > sDims.InnerWidthPx || bounds.Dy() > sDims.InnerHeightPx {
+		Debug(fmt.Sprintf("Image dimensions %dx%d exceed available space %dx%d",
+			bounds.Dx(), bounds.Dy(),
+			sDims.InnerWidthPx, sDims.InnerHeightPx), WARN)
+	}
+
+	// Band layout depends on the frame size, so start over whenever it changes
+	if r.bands == nil || r.bands.Width != bounds.Dx() || r.bands.Height != bounds.Dy() {
+		r.bands = NewBandManager(bounds.Dx(), bounds.Dy())
+		r.encoder = NewBandEncoder(cfg.Palette, bounds.Dx(), bounds.Dy())
+		r.fullRedraw = true
+		Debug(fmt.Sprintf("Created sixel band encoder for %dx%d (%d bands)",
+			bounds.Dx(), bounds.Dy(), r.bands.NumBands), INFO)
+	}
+
+	// Find the bands that changed
+	hashStart := time.Now()
+	r.bands.DetectDirtyBands(img, dirty)
+	dirtyBands := r.bands.GetDirtyBandCount()
+	hashTime := time.Since(hashStart)
+
+	// The adaptive palette is frozen so cached bands stay valid. It is only rebuilt
+	// when most of the frame changed (new page), and then every band is re-encoded anyway.
+	if r.encoder.IsAdaptive() &&
+		(r.encoder.Palette() == nil || dirtyBands > r.bands.NumBands/2) {
+		r.encoder.SetPalette(buildAdaptivePalette(img, sixelPaletteSize))
+		r.bands.MarkAllDirty()
+		dirtyBands = r.bands.NumBands
+		Debug(fmt.Sprintf("Rebuilt adaptive palette with %d colors", len(r.encoder.Palette())), DEBUG)
+	}
+
+	// Encode the dirty bands, reuse the cached encoding for the rest
+	encodeStart := time.Now()
+	bandStrings := make([]string, r.bands.NumBands)
+	for i := range r.bands.Bands {
+		band := &r.bands.Bands[i]
+		if band.IsDirty {
+			encoded, err := r.encoder.EncodeBand(img, band.Y, band.Height)
+			if err != nil {
+				Debug(fmt.Sprintf("Sixel encoding error: %v", err), ERROR)
+				return fmt.Errorf("sixel encoding error: %v", err)
+			}
+			band.CachedRLE = encoded
+		}
+		bandStrings[i] = band.CachedRLE
+	}
+
+	// Work out which rows to send. Partial images must start on a cell row, so the terminal
+	// can place them with a cursor move, and on a band boundary, so cached bands can be reused.
+	alignment := lcm(SIXEL_BAND_HEIGHT, charSize.Height)
+	regions := r.bands.DirtyRegions(alignment)
+	regionHeight := 0
+	for _, region := range regions {
+		regionHeight += region.Height
+	}
+	if r.fullRedraw || float64(regionHeight) > SIXEL_FULL_FRAME_RATIO*float64(bounds.Dy()) {
+		// One full image is cheaper than many partial ones (each carries its own palette),
+		// and after a clear everything has to be repainted anyway
+		regions = []BandRegion{{FirstBand: 0, EndBand: r.bands.NumBands, Y: 0, Height: bounds.Dy()}}
+		regionHeight = bounds.Dy()
+		r.fullRedraw = false
+	}
+
+	// Save cursor position before sixel output
+	buf.WriteString("\033[s")
+
+	outputBytes := 0
+	for _, region := range regions {
+		output := ComposeFullSixel(bandStrings[region.FirstBand:region.EndBand],
+			bounds.Dx(), region.Height, r.encoder.Palette())
+		outputBytes += len(output)
+
+		// Position cursor at the cell row where the region starts, inside the borders
+		// Add 1 to border width because terminal coordinates are 1-based
+		fmt.Fprintf(buf, "\033[%d;%dH", V_BORDER_WIDTH+1+region.Y/charSize.Height, H_BORDER_WIDTH+1)
+		buf.WriteString(output)
+	}
+
+	// Restore cursor position
+	buf.WriteString("\033[u")
+	encodeTime := time.Since(encodeStart)
+
+	if cfg.ShowTimings {
+		fmt.Fprintf(os.Stderr, "  Sixel encode time: %v hash time: %v (rendered size: %dx%d pixels, %d bytes)\n",
+			encodeTime, hashTime, bounds.Dx(), bounds.Dy(), outputBytes)
+		fmt.Fprintf(os.Stderr, "  Dirty bands: %d/%d (%.1f%%), sent %d region(s) covering %d rows\n",
+			dirtyBands, r.bands.NumBands, 100*float64(dirtyBands)/float64(r.bands.NumBands),
+			len(regions), regionHeight)
+		os.Stderr.Sync() // Force flush stderr
+	}
+
+	r.stats.record(time.Since(sixelStart), outputBytes)
+	r.stats.Detail = fmt.Sprintf("DirtyBands=%d/%d", dirtyBands, r.bands.NumBands)
+	Debug(fmt.Sprintf("Displayed sixel image at (%d,%d) with size %dx%d, %d/%d bands dirty in %d region(s) (took %v)",
+		H_BORDER_WIDTH, V_BORDER_WIDTH,
+		bounds.Dx(), bounds.Dy(),
+		dirtyBands, r.bands.NumBands, len(regions),
+		time.Since(sixelStart)), DEBUG)
+
+	return nil
+}
+
+// lcm returns the least common multiple of a and b
+func lcm(a, b int) int {
+	if a <= 0 || b <= 0 {
+		return max(a, b)
+	}
+	x, y := a, b
+	for y != 0 {
+		x, y = y, x%y
+	}
+	return a / x * b
+}
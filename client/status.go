@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gdamore/tcell/v2"
+	pb "termium/client/pb"
+)
+
+// Latest status of every tab as pushed by the server over the session stream
+var pageStatus struct {
+	sync.Mutex
+	tabs map[string]*pb.PageEvent
+}
+
+// redrawStatusLine is posted as an interrupt when the status line needs repainting from the event loop
+type statusLineRedraw struct{}
+
+var redrawStatusLine = statusLineRedraw{}
+
+// handlePageEvent records a page status change pushed by the server and repaints the status line
+// or tab strip when it concerns them
+func handlePageEvent(s tcell.Screen, event *pb.PageEvent) {
+	Debug(fmt.Sprintf("Page event: %v", event), DEBUG)
+
+	pageStatus.Lock()
+	if pageStatus.tabs == nil {
+		pageStatus.tabs = make(map[string]*pb.PageEvent)
+	}
+	pageStatus.tabs[event.TabId] = event
+	pageStatus.Unlock()
+
+	if event.TabId == activeTabID() {
+		frameActivity()
+	}
+
+	// Titles and URLs also show in the tab strip
+	if updateTabFromStatus(event) {
+		s.PostEvent(tcell.NewEventInterrupt(redrawTabStrip))
+	} else if event.TabId == activeTabID() {
+		s.PostEvent(tcell.NewEventInterrupt(redrawStatusLine))
+	}
+}
+
+// activePageStatus returns the active tab's status, nil if none was received yet
+func activePageStatus() *pb.PageEvent {
+	id := activeTabID()
+	pageStatus.Lock()
+	defer pageStatus.Unlock()
+	return pageStatus.tabs[id]
+}
+
+// drawStatusLine draws the active tab's load state, security state, title and URL into the
+// divider between the browser panel and the log panel, or the banner while disconnected
+func drawStatusLine(s tcell.Screen) {
+	borderStyle := tcell.StyleDefault.Foreground(tcell.ColorTeal)
+	y := sDims.LogPanelTop
+
+	// Restore the plain divider first, a shorter status leaves the old one behind otherwise
+	for x := 1; x < sDims.Width-1; x++ {
+		s.SetContent(x, y, '─', nil, borderStyle)
+	}
+
+	// While disconnected the status is stale; the banner takes its place. It is drawn here rather
+	// than over the page because kitty graphics cover text.
+	if banner := connectionBanner(); banner != "" {
+		style := tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorMaroon).Bold(true)
+		x := 2
+		for _, ch := range banner {
+			if x >= sDims.Width-2 {
+				break
+			}
+			s.SetContent(x, y, ch, nil, style)
+			x++
+		}
+		return
+	}
+
+	status := activePageStatus()
+	if status == nil {
+		return
+	}
+
+	type segment struct {
+		text  string
+		style tcell.Style
+	}
+	var segments []segment
+
+	if status.Loading {
+		segments = append(segments, segment{fmt.Sprintf(" Loading %d%% ", status.Progress),
+			tcell.StyleDefault.Foreground(tcell.ColorBlack).Background(tcell.ColorYellow)})
+	} else {
+		segments = append(segments, segment{" Done ", tcell.StyleDefault.Foreground(tcell.ColorSilver)})
+	}
+
+	switch status.Security {
+	case pb.SecurityState_SECURITY_STATE_SECURE:
+		segments = append(segments, segment{" Secure ", tcell.StyleDefault.Foreground(tcell.ColorGreen)})
+	case pb.SecurityState_SECURITY_STATE_INSECURE:
+		segments = append(segments, segment{" Not secure ", tcell.StyleDefault.Foreground(tcell.ColorRed)})
+	case pb.SecurityState_SECURITY_STATE_BROKEN:
+		segments = append(segments, segment{" Certificate error ",
+			tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorRed).Bold(true)})
+	}
+
+	page := status.Url
+	if status.Title != "" {
+		page = status.Title + " - " + status.Url
+	}
+	segments = append(segments, segment{" " + page + " ", tcell.StyleDefault.Foreground(tcell.ColorWhite)})
+
+	x := 2
+	for _, seg := range segments {
+		for _, ch := range seg.text {
+			if x >= sDims.Width-2 {
+				return
+			}
+			s.SetContent(x, y, ch, nil, seg.style)
+			x++
+		}
+		x++ // Leave a bit of divider between segments
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/gdamore/tcell/v2"
+	pb "termium/client/pb"
+)
+
+const (
+	TAB_MAX_TITLE_WIDTH = 24 // Longer titles are cut short in the tab strip
+	TAB_QUEUE_SIZE      = 16 // Tab operations that can wait for the ones before them
+)
+
+// The browser's tabs as last reported by the server
+var tabState struct {
+	sync.Mutex
+	tabs     []*pb.Tab
+	activeID string
+	// Screen columns each tab occupies in the tab strip, for mouse clicks: [start, end)
+	spans [][2]int
+}
+
+// activeTabID returns the ID of the tab input is sent to
+func activeTabID() string {
+	tabState.Lock()
+	defer tabState.Unlock()
+	return tabState.activeID
+}
+
+// activeTabURL returns the active tab's address as last reported by the server, without asking it:
+// the page events pushed over the session stream keep it current
+func activeTabURL() string {
+	if status := activePageStatus(); status != nil {
+		return status.Url
+	}
+	tabState.Lock()
+	defer tabState.Unlock()
+	for _, tab := range tabState.tabs {
+		if tab.TabId == tabState.activeID {
+			return tab.Url
+		}
+	}
+	return ""
+}
+
+// refreshTabs fetches the tab list from the server
+func refreshTabs() error {
+	list, err := grpcClient.ListTabs(context.Background(), &pb.Empty{})
+	if err != nil {
+		return fmt.Errorf("failed to list tabs: %v", err)
+	}
+
+	tabState.Lock()
+	defer tabState.Unlock()
+	tabState.tabs = list.Tabs
+	for _, tab := range list.Tabs {
+		if tab.Active {
+			tabState.activeID = tab.TabId
+		}
+	}
+	return nil
+}
+
+// updateTabFromStatus copies a page's title and URL into the tab list, returning whether anything changed
+func updateTabFromStatus(event *pb.PageEvent) bool {
+	tabState.Lock()
+	defer tabState.Unlock()
+	for _, tab := range tabState.tabs {
+		if tab.TabId == event.TabId && (tab.Title != event.Title || tab.Url != event.Url) {
+			tab.Title, tab.Url = event.Title, event.Url
+			return true
+		}
+	}
+	return false
+}
+
+// Tab operations waiting to run. They run one at a time, off the event loop so a slow server
+// can't freeze the UI, and in the order asked: each starts from the tab the one before left active.
+var tabQueue = make(chan func(), TAB_QUEUE_SIZE)
+var startTabQueue sync.Once
+
+// runTabCommand queues a tab operation, reporting its failure in the log panel. done runs after it
+// succeeded, on the same goroutine.
+func runTabCommand(s tcell.Screen, command func(tcell.Screen) error, done func()) {
+	startTabQueue.Do(func() {
+		go func() {
+			for f := range tabQueue {
+				f()
+			}
+		}()
+	})
+
+	f := func() {
+		if err := command(s); err != nil {
+			Debug(err.Error(), ERROR)
+			logBuffer.Write([]byte(err.Error()))
+			displayBottomPanel(s)
+			return
+		}
+		if done != nil {
+			done()
+		}
+	}
+	select {
+	case tabQueue <- f:
+	default:
+		logBuffer.Write([]byte("Too many tab operations waiting, ignored one"))
+		displayBottomPanel(s)
+	}
+}
+
+// redrawTabStrip is posted as an interrupt when the tab strip needs repainting from the event loop
+type tabStripRedraw struct{}
+
+var redrawTabStrip = tabStripRedraw{}
+
+// openTab opens a new tab, which becomes the active one
+func openTab(s tcell.Screen) error {
+	tab, err := grpcClient.OpenTab(context.Background(), &pb.Empty{})
+	if err != nil {
+		return fmt.Errorf("failed to open new tab: %v", err)
+	}
+	Debug(fmt.Sprintf("Opened tab %s", tab.TabId), DEBUG)
+
+	tabState.Lock()
+	tabState.activeID = tab.TabId
+	tabState.Unlock()
+
+	return tabChanged(s)
+}
+
+// switchTab makes the tab with the given ID the active one
+func switchTab(s tcell.Screen, id string) error {
+	tab, err := grpcClient.SwitchTab(context.Background(), &pb.TabRequest{TabId: id})
+	if err != nil {
+		return fmt.Errorf("failed to switch tab: %v", err)
+	}
+	Debug(fmt.Sprintf("Switched to tab %s", tab.TabId), DEBUG)
+
+	tabState.Lock()
+	tabState.activeID = tab.TabId
+	tabState.Unlock()
+
+	return tabChanged(s)
+}
+
+// closeTab closes the active tab; the server activates a neighbour
+func closeTab(s tcell.Screen) error {
+	resp, err := grpcClient.CloseTab(context.Background(), &pb.TabRequest{TabId: activeTabID()})
+	if err != nil {
+		return fmt.Errorf("failed to close tab: %v", err)
+	}
+	logBuffer.Write([]byte(resp.Text))
+
+	return tabChanged(s)
+}
+
+// cycleTab switches to the tab delta positions away from the active one, wrapping around
+func cycleTab(s tcell.Screen, delta int) error {
+	tabState.Lock()
+	next := ""
+	for i, tab := range tabState.tabs {
+		if tab.TabId == tabState.activeID {
+			n := len(tabState.tabs)
+			next = tabState.tabs[((i+delta)%n+n)%n].TabId
+			break
+		}
+	}
+	tabState.Unlock()
+
+	if next == "" || next == activeTabID() {
+		return nil
+	}
+	return switchTab(s, next)
+}
+
+// tabChanged refreshes the tab list and repaints the tab strip after the active tab changed.
+// The screenshot stream follows the active tab by itself.
+func tabChanged(s tcell.Screen) error {
+	if err := refreshTabs(); err != nil {
+		return err
+	}
+	s.PostEvent(tcell.NewEventInterrupt(redrawTabStrip))
+	return nil
+}
+
+// tabAt returns the ID of the tab drawn at column x of the tab strip, or "" if there is none
+func tabAt(x int) string {
+	tabState.Lock()
+	defer tabState.Unlock()
+	for i, span := range tabState.spans {
+		if x >= span[0] && x < span[1] && i < len(tabState.tabs) {
+			return tabState.tabs[i].TabId
+		}
+	}
+	return ""
+}
+
+// drawTabStrip draws the tabs into the top border, the row right above the browser panel
+func drawTabStrip(s tcell.Screen) {
+	borderStyle := tcell.StyleDefault.Foreground(tcell.ColorTeal)
+	tabStyle := tcell.StyleDefault.Foreground(tcell.ColorSilver)
+	activeStyle := tcell.StyleDefault.Foreground(tcell.ColorBlack).Background(tcell.ColorTeal).Bold(true)
+
+	// Restore the plain border first, a closed tab leaves its title behind otherwise
+	for x := 1; x < sDims.Width-1; x++ {
+		s.SetContent(x, 0, '─', nil, borderStyle)
+	}
+
+	tabState.Lock()
+	defer tabState.Unlock()
+
+	tabState.spans = tabState.spans[:0]
+	x := 2
+	for i, tab := range tabState.tabs {
+		title := tab.Title
+		if title == "" {
+			title = tab.Url
+		}
+		if title == "" {
+			title = "New Tab"
+		}
+		label := []rune(fmt.Sprintf(" %d: %s ", i+1, title))
+		if len(label) > TAB_MAX_TITLE_WIDTH {
+			label = append(label[:TAB_MAX_TITLE_WIDTH-2], '…', ' ')
+		}
+
+		style := tabStyle
+		if tab.TabId == tabState.activeID {
+			style = activeStyle
+		}
+
+		start := x
+		for _, ch := range label {
+			if x >= sDims.Width-2 {
+				break
+			}
+			s.SetContent(x, 0, ch, nil, style)
+			x++
+		}
+		tabState.spans = append(tabState.spans, [2]int{start, x})
+		x++ // Leave a bit of border between tabs
+	}
+}
+
+// Whether the left button was already down at the last mouse event, so holding it switches tabs only once
+var tabStripPressed bool
+
+// handleTabStripClick switches to the tab clicked in the tab strip
+func handleTabStripClick(s tcell.Screen, x, y int, buttons tcell.ButtonMask) {
+	if buttons&tcell.Button1 == 0 {
+		tabStripPressed = false
+		return
+	}
+	if tabStripPressed {
+		return
+	}
+	tabStripPressed = true
+	if y != 0 {
+		return
+	}
+
+	id := tabAt(x)
+	if id == "" || id == activeTabID() {
+		return
+	}
+	runTabCommand(s, func(s tcell.Screen) error { return switchTab(s, id) }, nil)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	pb "termium/client/pb"
+)
+
+func TestActiveTabURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		tabs   []*pb.Tab
+		events []*pb.PageEvent
+		want   string
+	}{
+		{"nothing known", nil, nil, ""},
+		{"from the tab list", []*pb.Tab{{TabId: "1", Url: "https://a.example/"}, {TabId: "2", Url: "https://b.example/"}},
+			nil, "https://b.example/"},
+		{"page event is newer", []*pb.Tab{{TabId: "2", Url: "https://b.example/"}},
+			[]*pb.PageEvent{{TabId: "2", Url: "https://b.example/next"}}, "https://b.example/next"},
+		{"other tabs' events ignored", []*pb.Tab{{TabId: "2", Url: "https://b.example/"}},
+			[]*pb.PageEvent{{TabId: "1", Url: "https://a.example/next"}}, "https://b.example/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tabState.Lock()
+			tabState.tabs, tabState.activeID = tt.tabs, "2"
+			tabState.Unlock()
+			pageStatus.Lock()
+			pageStatus.tabs = make(map[string]*pb.PageEvent)
+			for _, event := range tt.events {
+				pageStatus.tabs[event.TabId] = event
+			}
+			pageStatus.Unlock()
+
+			if got := activeTabURL(); got != tt.want {
+				t.Errorf("activeTabURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunTabCommandKeepsOrder(t *testing.T) {
+	s := testScreen(t)
+	var ran []int
+	finished := make(chan struct{})
+	for i := 0; i < 5; i++ {
+		runTabCommand(s, func(tcell.Screen) error {
+			ran = append(ran, i)
+			if i == 2 {
+				return errors.New("no such tab")
+			}
+			return nil
+		}, nil)
+	}
+	runTabCommand(s, func(tcell.Screen) error { return nil }, func() { close(finished) })
+
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("tab commands did not finish")
+	}
+	if !reflect.DeepEqual(ran, []int{0, 1, 2, 3, 4}) {
+		t.Errorf("ran %v, want every command in order", ran)
+	}
+}
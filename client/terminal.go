@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"golang.org/x/term"
+)
+
+// How long to wait for the terminal to answer the capability probe. Terminals answer in
+// order, so the DA1 reply at the end normally ends the probe long before this.
+const TERMINAL_PROBE_TIMEOUT = 500 * time.Millisecond
+
+// After a probe timed out, how long replies still on their way are read and dropped.
+// Once tcell owns stdin it would take them for key presses and type them into the page.
+const TERMINAL_PROBE_DRAIN = 300 * time.Millisecond
+
+// TerminalCaps holds what the terminal reported about itself
+type TerminalCaps struct {
+	Responded      bool // The terminal answered DA1 - everything below is trustworthy
+	Sixel          bool // DA1 attribute 4
+	Kitty          bool // Kitty graphics protocol query answered OK
+	ColorRegisters int  // XTSMGRAPHICS sixel color registers, 0 if unknown
+	SixelMaxWidth  int  // XTSMGRAPHICS maximum sixel geometry, 0 if unknown
+	SixelMaxHeight int
+	CellWidth      int // CSI 16 t cell size in pixels, 0 if unknown
+	CellHeight     int
+	WindowWidth    int // CSI 14 t text area size in pixels, 0 if unknown
+	WindowHeight   int
+	Rows           int // CSI 18 t text area size in characters, 0 if unknown
+	Cols           int
+}
+
+var termCaps TerminalCaps
+
+// The probe: Kitty graphics query, XTSMGRAPHICS color registers and max geometry,
+// cell size, window size in pixels and characters, and finally DA1, which every terminal answers
+var terminalProbe = fmt.Sprintf("\033_Gi=%d,s=1,v=1,a=q,t=d,f=24;AAAA\033\\", KITTY_QUERY_ID) +
+	"\033[?1;1;0S" +
+	"\033[?2;1;0S" +
+	"\033[16t" +
+	"\033[14t" +
+	"\033[18t" +
+	"\033[c"
+
+var (
+	da1Pattern     = regexp.MustCompile(`\x1b\[\?([0-9;]*)c`)
+	xtsmPattern    = regexp.MustCompile(`\x1b\[\?(\d+);(\d+);([0-9;]*)S`)
+	windowPattern  = regexp.MustCompile(`\x1b\[(\d+);(\d+);(\d+)t`)
+	kittyOKPattern = regexp.MustCompile(fmt.Sprintf(`\x1b_Gi=%d;OK`, KITTY_QUERY_ID))
+)
+
+// detectTerminalAndCalibrate probes the terminal's capabilities and calibrates the character size
+func detectTerminalAndCalibrate() {
+	termType := os.Getenv("TERM")
+	Debug(fmt.Sprintf("Terminal type: %s", termType), DEBUG)
+
+	caps, err := probeTerminal(TERMINAL_PROBE_TIMEOUT)
+	if err != nil {
+		Debug(fmt.Sprintf("Terminal capability probe failed: %v", err), WARN)
+	}
+	termCaps = caps
+	Debug(fmt.Sprintf("Terminal capabilities: %+v", termCaps), DEBUG)
+
+	// The kernel's window size is instant and exact, the probe replies come next
+	err = calibrateFromWinsize()
+	if err == nil {
+		return
+	}
+	Debug(fmt.Sprintf("Window size calibration failed: %v", err), DEBUG)
+
+	if err := calibrateFromCaps(termCaps); err != nil {
+		Debug(fmt.Sprintf("Terminal calibration failed: %v", err), WARN)
+		Debug("Falling back to default character size", INFO)
+		setDefaultCharSize()
+	}
+}
+
+// calibrateFromWinsize derives the character size from the TIOCGWINSZ pixel dimensions
+func calibrateFromWinsize() error {
+	cellWidth, cellHeight, err := winsizeCellSize()
+	if err != nil {
+		return err
+	}
+
+	charSize.Width = cellWidth
+	charSize.Height = cellHeight
+	Debug(fmt.Sprintf("Calibrated character size from window size: %dx%d pixels", charSize.Width, charSize.Height), INFO)
+	return nil
+}
+
+// winsizeCellSize computes the cell size from the TIOCGWINSZ pixel and character dimensions
+func winsizeCellSize() (int, int, error) {
+	cols, rows, widthPx, heightPx, err := terminalWindowSize()
+	if err != nil {
+		return 0, 0, err
+	}
+	if cols <= 0 || rows <= 0 || widthPx <= 0 || heightPx <= 0 {
+		return 0, 0, fmt.Errorf("terminal does not report its size in pixels")
+	}
+
+	cellWidth, cellHeight := widthPx/cols, heightPx/rows
+	if cellWidth < 1 || cellHeight < 1 {
+		return 0, 0, fmt.Errorf("unreasonable character size calculated: %dx%d", cellWidth, cellHeight)
+	}
+	return cellWidth, cellHeight, nil
+}
+
+// recalibrate re-measures the character size after a resize, as zooming the font changes it.
+// Only the window size can be used here - tcell owns stdin now, so escape query replies would
+// arrive as key events. Without it the size found at startup is kept.
+func recalibrate() {
+	oldSize := charSize
+	if err := calibrateFromWinsize(); err != nil {
+		return
+	}
+	if charSize != oldSize {
+		Debug(fmt.Sprintf("Character size changed from %dx%d to %dx%d pixels",
+			oldSize.Width, oldSize.Height, charSize.Width, charSize.Height), INFO)
+	}
+}
+
+// checkCellSize is posted as an interrupt on every window change. The cell size is compared on
+// the event loop, which is the one that calibrates it.
+type cellSizeCheck struct{}
+
+var checkCellSize = cellSizeCheck{}
+
+// watchCellSize has the event loop check the cell size on every window change, as it can change
+// without the window changing its size in characters, e.g. on font zoom in a terminal that keeps
+// its column count. tcell only reports resizes that change the number of rows or columns.
+func watchCellSize(s tcell.Screen) {
+	winchChan := make(chan os.Signal, 1)
+	notifyWindowChange(winchChan)
+	go func() {
+		for range winchChan {
+			s.PostEvent(tcell.NewEventInterrupt(checkCellSize))
+		}
+	}()
+}
+
+// resizeOnCellSizeChange posts a resize event when the window's cell size is no longer the
+// calibrated one. Called on the event loop only.
+func resizeOnCellSizeChange(s tcell.Screen) {
+	cellWidth, cellHeight, err := winsizeCellSize()
+	if err != nil || (cellWidth == charSize.Width && cellHeight == charSize.Height) {
+		return
+	}
+	Debug(fmt.Sprintf("Cell size changed to %dx%d pixels", cellWidth, cellHeight), DEBUG)
+	s.PostEvent(tcell.NewEventResize(s.Size()))
+}
+
+// probeTerminal sends all capability queries at once and collects the replies until the
+// terminal answers DA1 or the timeout expires, so a silent terminal cannot hang startup
+func probeTerminal(timeout time.Duration) (TerminalCaps, error) {
+	var caps TerminalCaps
+
+	if !terminalQueriesSupported {
+		return caps, fmt.Errorf("terminal queries are not supported on this platform")
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return caps, fmt.Errorf("not a terminal")
+	}
+
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return caps, err
+	}
+	defer term.Restore(fd, oldState)
+
+	if _, err := fmt.Fprint(os.Stdout, terminalProbe); err != nil {
+		return caps, err
+	}
+
+	var response strings.Builder
+	if err := readReplies(fd, &response, timeout); err != nil {
+		return parseTerminalResponse(response.String()), err
+	}
+	Debug(fmt.Sprintf("Raw terminal probe response: %q", response.String()), DEBUG)
+
+	caps = parseTerminalResponse(response.String())
+	if !caps.Responded {
+		// A slow terminal may still answer; the DA1 reply tells when it is done
+		var late strings.Builder
+		readReplies(fd, &late, TERMINAL_PROBE_DRAIN)
+		Debug(fmt.Sprintf("Dropped late terminal probe response: %q", late.String()), DEBUG)
+		return caps, fmt.Errorf("no reply within %v", timeout)
+	}
+	return caps, nil
+}
+
+// readReplies reads query replies into response until the DA1 reply ends them or timeout expires
+func readReplies(fd int, response *strings.Builder, timeout time.Duration) error {
+	chunk := make([]byte, 256)
+	deadline := time.Now().Add(timeout)
+	for !da1Pattern.MatchString(response.String()) {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil
+		}
+		n, err := readWithTimeout(fd, chunk, remaining)
+		if err != nil {
+			return err
+		}
+		response.Write(chunk[:n])
+	}
+	return nil
+}
+
+// parseTerminalResponse extracts the capabilities from the concatenated probe replies
+func parseTerminalResponse(response string) TerminalCaps {
+	var caps TerminalCaps
+
+	if m := da1Pattern.FindStringSubmatch(response); m != nil {
+		caps.Responded = true
+		for _, attr := range strings.Split(m[1], ";") {
+			if attr == "4" {
+				caps.Sixel = true
+			}
+		}
+	}
+
+	caps.Kitty = kittyOKPattern.MatchString(response)
+
+	// ESC [ ? Pi ; Ps ; Pv S - Ps 0 means success
+	for _, m := range xtsmPattern.FindAllStringSubmatch(response, -1) {
+		if m[2] != "0" {
+			continue
+		}
+		values := atoiList(m[3])
+		switch {
+		case m[1] == "1" && len(values) >= 1:
+			caps.ColorRegisters = values[0]
+		case m[1] == "2" && len(values) >= 2:
+			caps.SixelMaxWidth, caps.SixelMaxHeight = values[0], values[1]
+		}
+	}
+
+	// ESC [ 6 ; h ; w t (cell), ESC [ 4 ; h ; w t (window pixels), ESC [ 8 ; rows ; cols t (window chars)
+	for _, m := range windowPattern.FindAllStringSubmatch(response, -1) {
+		a, _ := strconv.Atoi(m[2])
+		b, _ := strconv.Atoi(m[3])
+		switch m[1] {
+		case "6":
+			caps.CellHeight, caps.CellWidth = a, b
+		case "4":
+			caps.WindowHeight, caps.WindowWidth = a, b
+		case "8":
+			caps.Rows, caps.Cols = a, b
+		}
+	}
+
+	return caps
+}
+
+// atoiList parses a semicolon separated list of integers
+func atoiList(s string) []int {
+	var values []int
+	for _, field := range strings.Split(s, ";") {
+		if v, err := strconv.Atoi(field); err == nil {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// calibrateFromCaps derives the character size from the probe results.
+// The cell size reply is used directly; otherwise it is computed from the window size.
+func calibrateFromCaps(caps TerminalCaps) error {
+	Debug("Starting terminal calibration", DEBUG)
+
+	switch {
+	case caps.CellWidth > 0 && caps.CellHeight > 0:
+		charSize.Width = caps.CellWidth
+		charSize.Height = caps.CellHeight
+	case caps.WindowWidth > 0 && caps.WindowHeight > 0 && caps.Rows > 0 && caps.Cols > 0:
+		charSize.Width = caps.WindowWidth / caps.Cols
+		charSize.Height = caps.WindowHeight / caps.Rows
+	default:
+		return fmt.Errorf("terminal did not report its cell or window size")
+	}
+
+	Debug(fmt.Sprintf("Calibrated character size: %dx%d pixels", charSize.Width, charSize.Height), INFO)
+
+	// Sanity check the results
+	if charSize.Width < 1 || charSize.Height < 1 {
+		Debug(fmt.Sprintf("Unreasonable character size calculated: %dx%d", charSize.Width, charSize.Height), ERROR)
+		return fmt.Errorf("unreasonable character size calculated")
+	}
+
+	return nil
+}
+
+// selectRenderer resolves the "auto" renderer to the best graphics protocol the terminal supports
+func selectRenderer() {
+	if cfg.Renderer != "auto" {
+		Debug(fmt.Sprintf("Using %s renderer", cfg.Renderer), DEBUG)
+		return
+	}
+
+	switch {
+	case termCaps.Kitty || kittyFromEnvironment():
+		cfg.Renderer = "kitty"
+	case detectITermImages():
+		cfg.Renderer = "iterm2"
+	case termCaps.Sixel:
+		cfg.Renderer = "sixel"
+	case termCaps.Responded:
+		// The terminal answered but has no graphics support at all
+		cfg.Renderer = "tcell"
+	default:
+		// Nothing is known, sixel is what we have always assumed
+		cfg.Renderer = "sixel"
+	}
+	Debug(fmt.Sprintf("Auto-selected %s renderer", cfg.Renderer), INFO)
+}
+
+// selectPalette fits the sixel palette to the number of color registers the terminal reported
+func selectPalette() {
+	registers := termCaps.ColorRegisters
+	if registers <= 0 || registers >= SIXEL_MAX_COLORS {
+		return
+	}
+
+	sixelPaletteSize = registers
+	if (cfg.Palette == "websafe" && registers < 216) || cfg.Palette == "plan9" {
+		Debug(fmt.Sprintf("Terminal only has %d color registers, using adaptive palette instead of %s",
+			registers, cfg.Palette), WARN)
+		cfg.Palette = "adaptive"
+	}
+	Debug(fmt.Sprintf("Sixel palette limited to %d colors", sixelPaletteSize), INFO)
+}
+
+// setDefaultCharSize sets default character size when calibration fails
+func setDefaultCharSize() {
+	charSize = CharSize{Width: 8, Height: 16}
+	Debug("Using default character size: 8x16 pixels", DEBUG)
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestParseTerminalResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     TerminalCaps
+	}{
+		{"nothing", "", TerminalCaps{}},
+		{"DA1 without graphics", "\x1b[?62;22c", TerminalCaps{Responded: true}},
+		{"DA1 with sixel", "\x1b[?62;4;22c", TerminalCaps{Responded: true, Sixel: true}},
+		{"attribute 4 must match whole", "\x1b[?64;44c", TerminalCaps{Responded: true}},
+		{"kitty", "\x1b_Gi=31;OK\x1b\\\x1b[?62;c", TerminalCaps{Responded: true, Kitty: true}},
+		{"kitty error", "\x1b_Gi=31;EINVAL:bad\x1b\\\x1b[?62;c", TerminalCaps{Responded: true}},
+		{"XTSMGRAPHICS", "\x1b[?1;0;256S\x1b[?2;0;1000;800S\x1b[?62;4c", TerminalCaps{Responded: true, Sixel: true,
+			ColorRegisters: 256, SixelMaxWidth: 1000, SixelMaxHeight: 800}},
+		{"XTSMGRAPHICS failure ignored", "\x1b[?1;3;0S\x1b[?62;4c", TerminalCaps{Responded: true, Sixel: true}},
+		{"window reports", "\x1b[6;20;10t\x1b[4;480;800t\x1b[8;24;80t\x1b[?62c", TerminalCaps{Responded: true,
+			CellWidth: 10, CellHeight: 20, WindowWidth: 800, WindowHeight: 480, Rows: 24, Cols: 80}},
+		{"no DA1 keeps the rest", "\x1b[6;16;8t", TerminalCaps{CellWidth: 8, CellHeight: 16}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseTerminalResponse(tt.response); got != tt.want {
+				t.Errorf("parseTerminalResponse(%q) = %+v, want %+v", tt.response, got, tt.want)
+			}
+		})
+	}
+}
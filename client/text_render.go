@@ -26,7 +26,9 @@ const (
 	QUADRANT_LOWER_RIGHT = '▗'
 )
 
-func displayWithTcell(s tcell.Screen, img *image.RGBA) error {
+// displayWithTcell draws img with block characters. With dirty given, only the cells showing
+// those parts of img are set.
+func displayWithTcell(s tcell.Screen, img *image.RGBA, dirty []image.Rectangle) error {
 	srcWidth := img.Bounds().Dx()
 	srcHeight := img.Bounds().Dy()
 
@@ -61,6 +63,7 @@ func displayWithTcell(s tcell.Screen, img *image.RGBA) error {
 
 	// Enhance contrast and saturation
 	enhanceImage(scaledImg)
+	dirty = scaleRects(dirty, img.Bounds(), scaledImg.Bounds())
 
 	// Center the image
 	// Since we process 2x2 blocks, divide target dimensions by 2 to get character cell count
@@ -78,6 +81,9 @@ func displayWithTcell(s tcell.Screen, img *image.RGBA) error {
 
 				continue
 			}
+			if dirty != nil && !overlapsAny(image.Rect(x, y, x+2, y+2), dirty) {
+				continue
+			}
 
 			// Get colors for 2x2 block
 			topLeft := scaledImg.RGBAAt(x, y)
@@ -93,6 +99,16 @@ func displayWithTcell(s tcell.Screen, img *image.RGBA) error {
 	return nil
 }
 
+// overlapsAny reports whether r overlaps any of the rects
+func overlapsAny(r image.Rectangle, rects []image.Rectangle) bool {
+	for _, other := range rects {
+		if r.Overlaps(other) {
+			return true
+		}
+	}
+	return false
+}
+
 func enhanceImage(img *image.RGBA) {
 	bounds := img.Bounds()
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
@@ -0,0 +1,46 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const terminalQueriesSupported = true
+
+// readWithTimeout reads from fd, giving up after timeout so an unanswered query cannot block forever
+func readWithTimeout(fd int, buf []byte, timeout time.Duration) (int, error) {
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		n, err := unix.Poll(fds, int(timeout/time.Millisecond))
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 {
+			return 0, nil
+		}
+		return unix.Read(fd, buf)
+	}
+}
+
+// terminalWindowSize asks the kernel for the terminal size. The pixel size is 0 when the
+// terminal does not fill in ws_xpixel/ws_ypixel.
+func terminalWindowSize() (cols, rows, widthPx, heightPx int, err error) {
+	ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), int(ws.Xpixel), int(ws.Ypixel), nil
+}
+
+// notifyWindowChange relays SIGWINCH to c
+func notifyWindowChange(c chan<- os.Signal) {
+	signal.Notify(c, unix.SIGWINCH)
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Windows consoles deliver query replies through the console input API that tcell reads,
+// so the replies would show up as key presses - don't send any queries
+const terminalQueriesSupported = false
+
+// readWithTimeout is not available on Windows consoles; the probe is skipped and defaults are used
+func readWithTimeout(fd int, buf []byte, timeout time.Duration) (int, error) {
+	return 0, fmt.Errorf("terminal queries are not supported on Windows")
+}
+
+// terminalWindowSize is not available on Windows consoles, which have no pixel size to report
+func terminalWindowSize() (cols, rows, widthPx, heightPx int, err error) {
+	return 0, 0, 0, 0, fmt.Errorf("window size in pixels is not available on Windows")
+}
+
+// notifyWindowChange does nothing - Windows has no SIGWINCH, tcell reports resizes by itself
+func notifyWindowChange(c chan<- os.Signal) {}
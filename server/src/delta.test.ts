@@ -0,0 +1,83 @@
+import { test } from 'node:test';
+import * as assert from 'node:assert';
+import { DELTA_TILE_SIZE, Rect, TabPixels, changedRects, cropPixels, deltaRects } from './delta';
+
+// frame returns a black frame with the given pixels set to white
+function frame(width: number, height: number, white: [number, number][] = [], tabId = '1'): TabPixels {
+    const data = Buffer.alloc(width * height * 4);
+    for (const [x, y] of white) {
+        data.fill(0xff, (y * width + x) * 4, (y * width + x + 1) * 4);
+    }
+    return { data, width, height, tabId };
+}
+
+const T = DELTA_TILE_SIZE;
+
+test('changedRects', async (t) => {
+    const cases: { name: string; width: number; height: number; white: [number, number][]; want: Rect[] }[] = [
+        { name: 'nothing changed', width: 2 * T, height: 2 * T, white: [], want: [] },
+        { name: 'one tile', width: 2 * T, height: 2 * T, white: [[T + 1, 1]], want: [{ x: T, y: 0, width: T, height: T }] },
+        {
+            name: 'neighbours in a row merge', width: 3 * T, height: T, white: [[0, 0], [T, T - 1]],
+            want: [{ x: 0, y: 0, width: 2 * T, height: T }],
+        },
+        {
+            name: 'a gap splits the run', width: 3 * T, height: T, white: [[0, 0], [2 * T, 0]],
+            want: [{ x: 0, y: 0, width: T, height: T }, { x: 2 * T, y: 0, width: T, height: T }],
+        },
+        {
+            name: 'runs do not merge across rows', width: T, height: 2 * T, white: [[0, 0], [0, T]],
+            want: [{ x: 0, y: 0, width: T, height: T }, { x: 0, y: T, width: T, height: T }],
+        },
+        {
+            name: 'partial last tiles', width: T + 10, height: T + 5, white: [[T + 9, T + 4]],
+            want: [{ x: T, y: T, width: 10, height: 5 }],
+        },
+        {
+            name: 'run into a partial last tile', width: T + 10, height: T, white: [[T - 1, 0], [T, 0]],
+            want: [{ x: 0, y: 0, width: T + 10, height: T }],
+        },
+    ];
+    for (const c of cases) {
+        await t.test(c.name, () => {
+            const previous = frame(c.width, c.height);
+            const current = frame(c.width, c.height, c.white);
+            assert.deepStrictEqual(changedRects(previous.data, current.data, c.width, c.height), c.want);
+        });
+    }
+});
+
+test('cropPixels packs the rows of a rect', () => {
+    const { data } = frame(4, 3, [[1, 1], [2, 2]]);
+    const cropped = cropPixels(data, 4, { x: 1, y: 1, width: 2, height: 2 });
+    const white = Buffer.alloc(4, 0xff);
+    const black = Buffer.alloc(4);
+    assert.deepStrictEqual(cropped, Buffer.concat([white, black, black, white]));
+});
+
+test('deltaRects', async (t) => {
+    const base = frame(2 * T, 2 * T);
+    const cases: { name: string; last: TabPixels | null; current: TabPixels; want: Rect[] | null }[] = [
+        { name: 'first frame is a keyframe', last: null, current: base, want: null },
+        { name: 'unchanged frame has no rects', last: base, current: frame(2 * T, 2 * T), want: [] },
+        {
+            name: 'small change is a delta', last: base, current: frame(2 * T, 2 * T, [[0, 0]]),
+            want: [{ x: 0, y: 0, width: T, height: T }],
+        },
+        {
+            name: 'half changed is still a delta', last: base, current: frame(2 * T, 2 * T, [[0, 0], [T, 0]]),
+            want: [{ x: 0, y: 0, width: 2 * T, height: T }],
+        },
+        {
+            name: 'more than half changed is a keyframe', last: base,
+            current: frame(2 * T, 2 * T, [[0, 0], [T, 0], [0, T]]), want: null,
+        },
+        { name: 'another tab is a keyframe', last: base, current: frame(2 * T, 2 * T, [], '2'), want: null },
+        { name: 'another size is a keyframe', last: base, current: frame(2 * T, T), want: null },
+    ];
+    for (const c of cases) {
+        await t.test(c.name, () => {
+            assert.deepStrictEqual(deltaRects(c.last, c.current), c.want);
+        });
+    }
+});
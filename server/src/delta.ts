@@ -0,0 +1,65 @@
+// Delta frames: which parts of a frame changed since the last one sent. Kept apart from the
+// server, it needs neither a browser nor the generated protocol code and can be tested alone.
+
+// Delta frames compare frames in square tiles of this many pixels and send the ones that changed
+export const DELTA_TILE_SIZE = 64;
+// When more than this fraction of a frame changed, a keyframe is as small and quicker to apply
+export const DELTA_MAX_CHANGED = 0.5;
+
+// A part of a frame, in pixels. The same fields as a DirtyRect without its data.
+export type Rect = { x: number; y: number; width: number; height: number };
+// Raw RGBA pixels, rows packed
+export type Pixels = { data: Buffer; width: number; height: number };
+// A frame sent, with the tab it showed
+export type TabPixels = Pixels & { tabId: string };
+
+// changedRects compares two frames of the same size tile by tile and returns the tiles that
+// changed, joined into one rect where they are next to each other in a row
+export function changedRects(previous: Buffer, current: Buffer, width: number, height: number): Rect[] {
+    const rects: Rect[] = [];
+    const stride = width * 4;
+    for (let y = 0; y < height; y += DELTA_TILE_SIZE) {
+        const rows = Math.min(DELTA_TILE_SIZE, height - y);
+        let run: Rect | null = null;
+        for (let x = 0; x < width; x += DELTA_TILE_SIZE) {
+            const columns = Math.min(DELTA_TILE_SIZE, width - x);
+            let changed = false;
+            for (let row = y; row < y + rows && !changed; row++) {
+                const start = row * stride + x * 4;
+                const end = start + columns * 4;
+                changed = current.compare(previous, start, end, start, end) !== 0;
+            }
+            if (!changed) {
+                run = null;
+            } else if (run) {
+                run.width += columns;
+            } else {
+                run = { x, y, width: columns, height: rows };
+                rects.push(run);
+            }
+        }
+    }
+    return rects;
+}
+
+// cropPixels copies a rect out of a frame's pixels, its rows packed
+export function cropPixels(data: Buffer, width: number, { x, y, width: w, height: h }: Rect): Buffer {
+    const cropped = Buffer.alloc(w * h * 4);
+    for (let row = 0; row < h; row++) {
+        const start = ((y + row) * width + x) * 4;
+        data.copy(cropped, row * w * 4, start, start + w * 4);
+    }
+    return cropped;
+}
+
+// deltaRects returns the rects a frame can be sent as after the last one, or null when it has to
+// go out whole: there is no last frame, it showed another tab or size, or too much changed
+export function deltaRects(last: TabPixels | null, current: TabPixels): Rect[] | null {
+    const { data, width, height, tabId } = current;
+    if (!last || last.tabId !== tabId || last.width !== width || last.height !== height) {
+        return null;
+    }
+    const changed = changedRects(last.data, data, width, height);
+    const area = changed.reduce((sum, rect) => sum + rect.width * rect.height, 0);
+    return area <= DELTA_MAX_CHANGED * width * height ? changed : null;
+}
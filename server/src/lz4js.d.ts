@@ -0,0 +1,5 @@
+// lz4js comes without type definitions; only what the server uses is declared
+declare module 'lz4js' {
+    // Compresses to the LZ4 frame format
+    export function compress(data: Uint8Array, maxSize?: number): Uint8Array;
+}
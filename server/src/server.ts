@@ -3,19 +3,49 @@ import * as puppeteer from 'puppeteer';
 import { Command } from 'commander';
 import * as fs from 'fs';
 import * as path from 'path';
+import { EventEmitter } from 'events';
+import { Writable } from 'stream';
 import debugFactory from 'debug';
+import { PNG } from 'pngjs';
+import { compress as zstdCompress } from '@mongodb-js/zstd';
+import * as lz4 from 'lz4js';
+import { Pixels, TabPixels, cropPixels, deltaRects } from './delta';
 
 // Update import paths
-import { ServerUnaryCall, sendUnaryData, ServerWritableStream } from '@grpc/grpc-js';
+import { ServerUnaryCall, sendUnaryData, ServerWritableStream, ServerReadableStream, ServerDuplexStream } from '@grpc/grpc-js';
 import { BrowserControlService, BrowserControlServer } from '../generated/bc';
 import { Empty, Message, ViewportSize, Coordinate, Text, Url, Screenshot, ScreenshotRequest } from '../generated/bc';
+import { FrameFormat, frameFormatToJSON, FrameMetadata } from '../generated/bc';
+import { Tab, TabList, TabRequest, ReloadRequest } from '../generated/bc';
+import { PageEvent, PageEventType, SecurityState } from '../generated/bc';
+import { MouseButton, MouseEvent, WheelEvent, KeyEvent, KeyModifier, InputEvent } from '../generated/bc';
+import { ClientMessage, ServerMessage } from '../generated/bc';
 
 const program = new Command();
 const logDebug = debugFactory('server:debug');
 
-// Puppeteer browser and page instances
+// Puppeteer browser instance and its tabs by tab ID, in the order they were opened
 let browser: puppeteer.Browser | null = null;
-let page: puppeteer.Page | null = null;
+const tabs = new Map<string, puppeteer.Page>();
+let activeTabId = '';
+let nextTabId = 1;
+// Last viewport set by the client, applied to tabs opened later
+let viewport: puppeteer.Viewport | null = null;
+
+// Emits 'activated' with the tab ID when another tab becomes the active one
+const tabEvents = new EventEmitter();
+tabEvents.setMaxListeners(0);
+
+// activateTab makes a tab the active one and tells the streams following it
+function activateTab(id: string) {
+    // Keys held in the tab left behind would stay down there
+    const previous = tabs.get(activeTabId);
+    if (previous && id !== activeTabId) {
+        releaseKeys(previous).catch((error) => logDebug('Error releasing keys:', (error as Error).message));
+    }
+    activeTabId = id;
+    tabEvents.emit('activated', id);
+}
 
 // CLI setup with Commander
 program
@@ -67,18 +97,627 @@ async function launchOrConnectToBrowser() {
     }
 }
 
+// getTab returns the page for tabId, or the active tab's when tabId is empty
+function getTab(tabId: string): puppeteer.Page {
+    const id = tabId || activeTabId;
+    const page = tabs.get(id);
+    if (!page) {
+        throw new Error(id ? `No tab with ID ${id}` : 'No active page');
+    }
+    return page;
+}
+
+async function describeTab(id: string, page: puppeteer.Page): Promise<Tab> {
+    return { tabId: id, title: await page.title(), url: page.url(), active: id === activeTabId };
+}
+
+// Status of every tab, kept current by watchPage and pushed to PageEvents streams through pageEvents
+const pageStatus = new Map<string, PageEvent>();
+const pageEvents = new EventEmitter();
+pageEvents.setMaxListeners(0);
+
+// Progress events are sent at most this often while a page loads
+const PROGRESS_INTERVAL_MS = 250;
+
+// Security state from the URL alone, until the DevTools protocol reports the certificate state
+function securityFromUrl(url: string): SecurityState {
+    if (url.startsWith('https:')) return SecurityState.SECURITY_STATE_SECURE;
+    if (url.startsWith('http:')) return SecurityState.SECURITY_STATE_INSECURE;
+    return SecurityState.SECURITY_STATE_NEUTRAL;
+}
+
+function securityFromDevTools(state: string): SecurityState {
+    switch (state) {
+        case 'secure':
+            return SecurityState.SECURITY_STATE_SECURE;
+        case 'insecure':
+            return SecurityState.SECURITY_STATE_INSECURE;
+        case 'insecure-broken':
+            return SecurityState.SECURITY_STATE_BROKEN;
+        case 'neutral':
+            return SecurityState.SECURITY_STATE_NEUTRAL;
+        default:
+            return SecurityState.SECURITY_STATE_UNKNOWN;
+    }
+}
+
+// updatePageStatus applies a change to a tab's status and publishes it
+function updatePageStatus(tabId: string, type: PageEventType, change: Partial<PageEvent>) {
+    const status = pageStatus.get(tabId);
+    if (!status) return;
+    Object.assign(status, change, { type });
+    pageEvents.emit('event', { ...status });
+}
+
+// watchPage tracks title, URL, loading, progress and security state of a tab
+async function watchPage(tabId: string, page: puppeteer.Page) {
+    pageStatus.set(tabId, {
+        tabId,
+        type: PageEventType.PAGE_EVENT_SNAPSHOT,
+        title: '',
+        url: page.url(),
+        loading: false,
+        progress: 0,
+        security: securityFromUrl(page.url()),
+    });
+
+    const isMainFrame = (frame: puppeteer.Frame) => frame === page.mainFrame();
+
+    const updateTitle = async () => {
+        try {
+            const title = await page.title();
+            if (title !== pageStatus.get(tabId)?.title) {
+                updatePageStatus(tabId, PageEventType.PAGE_EVENT_TITLE_CHANGED, { title });
+            }
+        } catch (error) {
+            // The page navigated away or closed while we asked
+            logDebug('Error reading title:', (error as Error).message);
+        }
+    };
+
+    // Progress is estimated from the requests the page made so far and how many of them are done
+    let requestsStarted = 0;
+    let requestsDone = 0;
+    let lastProgress = 0;
+    const reportProgress = () => {
+        const status = pageStatus.get(tabId);
+        if (!status?.loading || Date.now() - lastProgress < PROGRESS_INTERVAL_MS) return;
+        lastProgress = Date.now();
+        // Keep a little in reserve - more requests usually follow until the load event
+        const progress = Math.min(95, Math.round(10 + 85 * requestsDone / Math.max(requestsStarted, 1)));
+        if (progress > status.progress) {
+            updatePageStatus(tabId, PageEventType.PAGE_EVENT_PROGRESS, { progress });
+        }
+    };
+    page.on('request', () => {
+        requestsStarted++;
+        reportProgress();
+    });
+    const requestDone = () => {
+        requestsDone++;
+        reportProgress();
+    };
+    page.on('requestfinished', requestDone);
+    page.on('requestfailed', requestDone);
+
+    const client = await page.createCDPSession();
+    const { frameTree } = await client.send('Page.getFrameTree');
+    const mainFrameId = frameTree.frame.id;
+    client.on('Page.frameStartedLoading', ({ frameId }) => {
+        if (frameId !== mainFrameId) return;
+        requestsStarted = 0;
+        requestsDone = 0;
+        updatePageStatus(tabId, PageEventType.PAGE_EVENT_LOAD_STARTED, { loading: true, progress: 0 });
+    });
+    // Also ends loads that never fire 'load': stopped or cancelled ones, 204s and downloads
+    client.on('Page.frameStoppedLoading', ({ frameId }) => {
+        if (frameId !== mainFrameId || !pageStatus.get(tabId)?.loading) return;
+        updatePageStatus(tabId, PageEventType.PAGE_EVENT_LOAD_FINISHED, { loading: false });
+    });
+    client.on('Security.visibleSecurityStateChanged', ({ visibleSecurityState }) => {
+        const security = securityFromDevTools(visibleSecurityState.securityState);
+        if (security !== pageStatus.get(tabId)?.security) {
+            updatePageStatus(tabId, PageEventType.PAGE_EVENT_SECURITY_CHANGED, { security });
+        }
+    });
+    await client.send('Page.enable');
+    await client.send('Security.enable');
+
+    page.on('framenavigated', (frame) => {
+        if (!isMainFrame(frame)) return;
+        const url = frame.url();
+        if (url !== pageStatus.get(tabId)?.url) {
+            updatePageStatus(tabId, PageEventType.PAGE_EVENT_URL_CHANGED, { url, security: securityFromUrl(url) });
+        }
+        updateTitle();
+    });
+    page.on('domcontentloaded', updateTitle);
+    page.on('load', () => {
+        updatePageStatus(tabId, PageEventType.PAGE_EVENT_PROGRESS, { progress: 100 });
+        updateTitle();
+    });
+    page.on('close', () => {
+        pageStatus.delete(tabId);
+        forgetHeldKeys(page);
+    });
+}
+
+// Map the protocol's mouse button to Puppeteer's
+function toPuppeteerButton(button: MouseButton): puppeteer.MouseButton {
+    switch (button) {
+        case MouseButton.MOUSE_BUTTON_MIDDLE:
+            return 'middle';
+        case MouseButton.MOUSE_BUTTON_RIGHT:
+            return 'right';
+        default:
+            return 'left';
+    }
+}
+
+// Puppeteer's names for the modifier keys, in the order they are pressed
+const modifierKeys: [KeyModifier, puppeteer.KeyInput][] = [
+    [KeyModifier.KEY_MODIFIER_CTRL, 'Control'],
+    [KeyModifier.KEY_MODIFIER_ALT, 'Alt'],
+    [KeyModifier.KEY_MODIFIER_META, 'Meta'],
+    [KeyModifier.KEY_MODIFIER_SHIFT, 'Shift'],
+];
+
+// Terminals only report key presses, never releases. The last key is kept down until the next
+// one arrives, so auto-repeat reaches the page as repeated keydowns, and released after
+// KEY_RELEASE_DELAY_MS when no more follow.
+const KEY_RELEASE_DELAY_MS = 100;
+// Keys are held per tab, each tab has a keyboard of its own
+type HeldKeys = { keys: puppeteer.KeyInput[]; releaseTimer: NodeJS.Timeout | null };
+const heldKeys = new Map<puppeteer.Page, HeldKeys>();
+
+// keysHeldIn returns the keys held in a tab, tracking it from the first key on
+function keysHeldIn(p: puppeteer.Page): HeldKeys {
+    let held = heldKeys.get(p);
+    if (!held) {
+        held = { keys: [], releaseTimer: null };
+        heldKeys.set(p, held);
+    }
+    return held;
+}
+
+async function releaseKeys(p: puppeteer.Page) {
+    const held = heldKeys.get(p);
+    if (!held) return;
+    if (held.releaseTimer) {
+        clearTimeout(held.releaseTimer);
+        held.releaseTimer = null;
+    }
+    const keys = held.keys;
+    held.keys = [];
+    for (const key of keys.reverse()) {
+        await p.keyboard.up(key);
+    }
+}
+
+// forgetHeldKeys drops the keys of a closed tab, there is nothing left to release them in
+function forgetHeldKeys(p: puppeteer.Page) {
+    const held = heldKeys.get(p);
+    if (held?.releaseTimer) {
+        clearTimeout(held.releaseTimer);
+    }
+    heldKeys.delete(p);
+}
+
+async function dispatchKeyEvent(p: puppeteer.Page, event: KeyEvent) {
+    const key = event.key as puppeteer.KeyInput;
+    const held = keysHeldIn(p);
+    const repeating = event.repeat && held.keys[held.keys.length - 1] === key;
+
+    if (!repeating) {
+        await releaseKeys(p);
+        for (const [modifier, name] of modifierKeys) {
+            if (event.modifiers & modifier) {
+                await p.keyboard.down(name);
+                held.keys.push(name);
+            }
+        }
+    } else if (held.releaseTimer) {
+        clearTimeout(held.releaseTimer);
+    }
+
+    try {
+        // Puppeteer marks the keydown as a repeat itself when the key is still down
+        await p.keyboard.down(key);
+        if (!repeating) {
+            held.keys.push(key);
+        }
+    } catch (error) {
+        // Not a key Puppeteer knows, e.g. a character from another keyboard layout - type it instead
+        if ([...event.key].length !== 1) throw error;
+        await p.keyboard.sendCharacter(event.key);
+    }
+
+    held.releaseTimer = setTimeout(() => {
+        releaseKeys(p).catch((error) => logDebug('Error releasing keys:', (error as Error).message));
+    }, KEY_RELEASE_DELAY_MS);
+}
+
+// Mouse input, shared by the single-event RPCs and the inputEvents stream. The pointer is moved
+// first so presses and wheel events go to the element under it.
+
+async function moveMouse(p: puppeteer.Page, { x, y }: MouseEvent) {
+    // Buttons still held from a press turn this into a drag
+    await p.mouse.move(x, y);
+}
+
+async function pressMouseButton(p: puppeteer.Page, { x, y, button, clickCount }: MouseEvent) {
+    await p.mouse.move(x, y);
+    await p.mouse.down({ button: toPuppeteerButton(button), clickCount: clickCount || 1 });
+}
+
+async function releaseMouseButton(p: puppeteer.Page, { x, y, button, clickCount }: MouseEvent) {
+    await p.mouse.move(x, y);
+    await p.mouse.up({ button: toPuppeteerButton(button), clickCount: clickCount || 1 });
+}
+
+async function scrollMouseWheel(p: puppeteer.Page, { x, y, deltaX, deltaY }: WheelEvent) {
+    await p.mouse.move(x, y);
+    await p.mouse.wheel({ deltaX, deltaY });
+}
+
+async function typeText(p: puppeteer.Page, text: string) {
+    // A key held for auto-repeat would otherwise still be down while the text is typed
+    await releaseKeys(p);
+    await p.keyboard.type(text);
+}
+
+// handleInputEvent dispatches one event of the inputEvents stream to the tab it names
+async function handleInputEvent(event: InputEvent) {
+    if (event.key) {
+        await dispatchKeyEvent(getTab(event.key.tabId), event.key);
+    } else if (event.text) {
+        await typeText(getTab(event.text.tabId), event.text.content);
+    } else if (event.mouseMove) {
+        await moveMouse(getTab(event.mouseMove.tabId), event.mouseMove);
+    } else if (event.mouseDown) {
+        await pressMouseButton(getTab(event.mouseDown.tabId), event.mouseDown);
+    } else if (event.mouseUp) {
+        await releaseMouseButton(getTab(event.mouseUp.tabId), event.mouseUp);
+    } else if (event.wheel) {
+        await scrollMouseWheel(getTab(event.wheel.tabId), event.wheel);
+    }
+}
+
+// applyViewport sets the viewport of one tab, or with an empty tab ID of every tab and the ones opened later
+async function applyViewport({ width, height, tabId }: ViewportSize) {
+    if (tabId) {
+        await getTab(tabId).setViewport({ width, height });
+        return;
+    }
+    viewport = { width, height };
+    for (const page of tabs.values()) {
+        await page.setViewport(viewport);
+    }
+}
+
+// Low levels are fast enough for every frame and still shrink page pixels a lot
+const ZSTD_LEVEL = 3;
+
+// The frame fields that describe the image, without the ones of the stream
+type FrameImage = Pick<Screenshot, 'data' | 'format' | 'width' | 'height' | 'stride'>;
+
+// capturePixels takes a screenshot of the page as raw RGBA pixels. Puppeteer only hands out
+// encoded images, so its PNG is decoded.
+async function capturePixels(page: puppeteer.Page): Promise<Pixels> {
+    const png = Buffer.from(await page.screenshot({ type: 'png', optimizeForSpeed: true }));
+    return decodePng(png);
+}
+
+// decodePng decodes a PNG into raw RGBA pixels
+function decodePng(png: Buffer): Pixels {
+    const { data, width, height } = PNG.sync.read(png);
+    return { data, width, height };
+}
+
+// rawFormat returns the raw format frames go out in. Formats this server cannot produce fall
+// back to plain RGBA, which the frame's format field reports.
+function rawFormat(format: FrameFormat): FrameFormat {
+    switch (format) {
+        case FrameFormat.FRAME_FORMAT_RGBA_ZSTD:
+        case FrameFormat.FRAME_FORMAT_RGBA_LZ4:
+            return format;
+        default:
+            return FrameFormat.FRAME_FORMAT_RGBA;
+    }
+}
+
+// compressPixels encodes raw RGBA pixels in a raw format
+async function compressPixels(data: Buffer, format: FrameFormat): Promise<Buffer> {
+    switch (format) {
+        case FrameFormat.FRAME_FORMAT_RGBA_ZSTD:
+            return zstdCompress(data, ZSTD_LEVEL);
+        case FrameFormat.FRAME_FORMAT_RGBA_LZ4:
+            return Buffer.from(lz4.compress(data));
+        default:
+            return data;
+    }
+}
+
+// captureFrame takes a screenshot of the page in the requested format
+async function captureFrame(page: puppeteer.Page, format = FrameFormat.FRAME_FORMAT_JPEG): Promise<FrameImage> {
+    switch (format) {
+        case FrameFormat.FRAME_FORMAT_JPEG: {
+            const screenshot = await page.screenshot({
+                type: 'jpeg',
+                quality: 60
+            });
+            return { data: Buffer.from(screenshot), format, width: 0, height: 0, stride: 0 };
+        }
+        case FrameFormat.FRAME_FORMAT_PNG: {
+            const screenshot = await page.screenshot({ type: 'png', optimizeForSpeed: true });
+            return { data: Buffer.from(screenshot), format, width: 0, height: 0, stride: 0 };
+        }
+    }
+    const { data, width, height } = await capturePixels(page);
+    const raw = rawFormat(format);
+    return { data: await compressPixels(data, raw), format: raw, width, height, stride: width * 4 };
+}
+
+// screencastFormat returns the image type to ask Chrome's screencast for. The raw formats are
+// decoded from PNG, which is lossless.
+function screencastFormat(format: FrameFormat): 'jpeg' | 'png' {
+    return format === FrameFormat.FRAME_FORMAT_JPEG ? 'jpeg' : 'png';
+}
+
+// The frames of one stream. With deltas it keeps the last frame sent, so the next one only needs
+// the tiles that changed.
+interface FrameStream {
+    capture(page: puppeteer.Page, tabId: string): Promise<Omit<Screenshot, 'inputSeq'>>;
+    // Turns a screencast frame, in the screencastFormat of the stream, into the next frame
+    screencast(image: Buffer, tabId: string): Promise<Omit<Screenshot, 'inputSeq'>>;
+    // The next frame goes out whole
+    requestKeyframe(): void;
+}
+
+function frameStream({ format, deltas }: ScreenshotRequest): FrameStream {
+    let seq = 0;
+    let previous: TabPixels | null = null;
+    // Only the raw formats can be patched, the others always go out whole
+    const encoded = format === FrameFormat.FRAME_FORMAT_JPEG || format === FrameFormat.FRAME_FORMAT_PNG;
+
+    const fromPixels = async ({ data, width, height }: Pixels, tabId: string) => {
+        const last = deltas ? previous : null;
+        previous = deltas ? { data, width, height, tabId } : null;
+        const raw = rawFormat(format);
+        const frame = { format: raw, width, height, stride: width * 4, seq };
+
+        const changed = deltaRects(last, { data, width, height, tabId });
+        if (changed) {
+            const rects = await Promise.all(changed.map(async (rect) => (
+                { ...rect, data: await compressPixels(cropPixels(data, width, rect), raw) }
+            )));
+            return { ...frame, data: Buffer.alloc(0), keyframe: false, rects };
+        }
+        return { ...frame, data: await compressPixels(data, raw), keyframe: true, rects: [] };
+    };
+
+    return {
+        capture: async (page, tabId) => {
+            seq++;
+            if (encoded) {
+                return { ...await captureFrame(page, format), seq, keyframe: true, rects: [] };
+            }
+            return fromPixels(await capturePixels(page), tabId);
+        },
+        screencast: async (image, tabId) => {
+            seq++;
+            if (encoded) {
+                return { data: image, format, width: 0, height: 0, stride: 0, seq, keyframe: true, rects: [] };
+            }
+            return fromPixels(decodePng(image), tabId);
+        },
+        requestKeyframe: () => {
+            previous = null;
+        },
+    };
+}
+
+// A running screencast, see screencastFrames
+interface Screencast {
+    // Frames go out at most fps a second; at 0 the screencast stops until the rate is set again
+    setFps(fps: number): void;
+    // Sends a screenshot, for a frame that can't wait for the page to repaint
+    refresh(): void;
+    stop(): void;
+}
+
+// toFrameMetadata copies what the screencast reports with a frame
+function toFrameMetadata(metadata: puppeteer.Protocol.Page.ScreencastFrameMetadata): FrameMetadata {
+    const { offsetTop, pageScaleFactor, deviceWidth, deviceHeight, scrollOffsetX, scrollOffsetY } = metadata;
+    return {
+        offsetTop, pageScaleFactor, deviceWidth, deviceHeight, scrollOffsetX, scrollOffsetY,
+        timestamp: metadata.timestamp ?? 0,
+    };
+}
+
+// drained waits until a stream that refused a write takes more, true then. It is false once the
+// stream closes or fails instead, which would leave a plain wait for 'drain' hanging forever.
+function drained(call: Writable): Promise<boolean> {
+    return new Promise((resolve) => {
+        if (call.destroyed || call.writableEnded) {
+            resolve(false);
+            return;
+        }
+        const done = (ok: boolean) => () => {
+            call.off('drain', onDrain);
+            call.off('close', onEnd);
+            call.off('cancelled', onEnd);
+            call.off('error', onEnd);
+            resolve(ok);
+        };
+        const onDrain = done(true);
+        const onEnd = done(false);
+        call.once('drain', onDrain);
+        call.once('close', onEnd);
+        call.once('cancelled', onEnd);
+        call.once('error', onEnd);
+    });
+}
+
+// screencastFrames sends the frames of Chrome's screencast of a tab, or of the active tab without a
+// tab ID, as the page repaints. A frame that comes sooner than 1000 / fps ms after the last one
+// waits, and a newer one replaces it, so the last repaint always goes out. Frames are not sent
+// while send is still busy with the one before.
+function screencastFrames(
+    { tabId, format }: ScreenshotRequest,
+    stream: FrameStream,
+    fps: number,
+    send: (frame: Omit<Screenshot, 'inputSeq'>) => Promise<void>,
+): Screencast {
+    let stopped = false;
+    let cast: {
+        id: string;
+        client: puppeteer.CDPSession;
+        onFrame: (event: puppeteer.Protocol.Page.ScreencastFrameEvent) => void;
+    } | null = null;
+    // Attaching and detaching take turns, so a quick run of tab switches leaves one screencast
+    let switching = Promise.resolve();
+    // The newest frame not sent yet; without an image it is a screenshot to take
+    let pending: { id: string; page: puppeteer.Page; image?: Buffer; metadata?: FrameMetadata } | null = null;
+    let sending = false;
+    let sentAt = 0;
+    let timer: NodeJS.Timeout | null = null;
+
+    const flush = async () => {
+        if (stopped || sending || timer || !pending || fps <= 0) {
+            return;
+        }
+        const wait = sentAt + 1000 / fps - Date.now();
+        if (wait > 0) {
+            timer = setTimeout(() => {
+                timer = null;
+                flush();
+            }, wait);
+            return;
+        }
+
+        const next = pending;
+        pending = null;
+        sending = true;
+        try {
+            const frame = next.image
+                ? await stream.screencast(next.image, next.id)
+                : await stream.capture(next.page, next.id);
+            sentAt = Date.now();
+            await send({ ...frame, metadata: next.metadata });
+        } catch (error) {
+            logDebug('Error sending screencast frame:', (error as Error).message);
+        } finally {
+            sending = false;
+        }
+        flush();
+    };
+
+    const refresh = () => {
+        const id = tabId || activeTabId;
+        const page = tabs.get(id);
+        if (page) {
+            pending = { id, page };
+            flush();
+        }
+    };
+
+    const detach = async () => {
+        const last = cast;
+        cast = null;
+        if (last) {
+            last.client.off('Page.screencastFrame', last.onFrame);
+            await last.client.send('Page.stopScreencast').catch(() => {});
+            await last.client.detach().catch(() => {});
+        }
+    };
+
+    const attach = async () => {
+        const id = tabId || activeTabId;
+        const page = tabs.get(id);
+        if (stopped || fps <= 0 || !page || cast?.id === id) {
+            return;
+        }
+        await detach();
+        const client = await page.createCDPSession();
+        const onFrame = ({ data, metadata, sessionId }: puppeteer.Protocol.Page.ScreencastFrameEvent) => {
+            // Chrome sends no more frames until this one is acknowledged
+            client.send('Page.screencastFrameAck', { sessionId }).catch(() => {});
+            pending = { id, page, image: Buffer.from(data, 'base64'), metadata: toFrameMetadata(metadata) };
+            flush();
+        };
+        cast = { id, client, onFrame };
+        client.on('Page.screencastFrame', onFrame);
+        await client.send('Page.startScreencast', { format: screencastFormat(format), quality: 60 });
+        // The screencast waits for the page to repaint, a static page would show nothing until then
+        refresh();
+    };
+
+    const follow = (change: () => Promise<void>) => {
+        switching = switching.then(change).catch((error) => {
+            logDebug('Error switching screencast:', (error as Error).message);
+        });
+    };
+    const activated = () => follow(attach);
+    if (!tabId) {
+        tabEvents.on('activated', activated);
+    }
+    follow(attach);
+
+    return {
+        setFps: (rate) => {
+            const resume = fps <= 0 && rate > 0;
+            fps = rate;
+            if (timer) {
+                clearTimeout(timer);
+                timer = null;
+            }
+            if (fps <= 0) {
+                follow(detach);
+            } else if (resume) {
+                follow(attach);
+            } else {
+                flush();
+            }
+        },
+        refresh,
+        stop: () => {
+            stopped = true;
+            if (timer) {
+                clearTimeout(timer);
+                timer = null;
+            }
+            tabEvents.off('activated', activated);
+            follow(detach);
+        },
+    };
+}
+
+// pageStatusSnapshots returns where every tab is at, for streams to start with
+function pageStatusSnapshots(): PageEvent[] {
+    return [...pageStatus.values()].map((status) => ({ ...status, type: PageEventType.PAGE_EVENT_SNAPSHOT }));
+}
+
 const browserControlHandlers: BrowserControlServer = {
-    openTab: async (_call: ServerUnaryCall<Empty, Message>, callback: sendUnaryData<Message>) => {
+    openTab: async (_call: ServerUnaryCall<Empty, Tab>, callback: sendUnaryData<Tab>) => {
         try {
             if (!browser) {
                 await launchOrConnectToBrowser();
             }
-            if (browser) {
-              page = await browser.newPage();
-            } else {
+            if (!browser) {
               throw new Error('Browser instance is not initiated.');
             }
-            callback(null, { text: 'New tab opened' });
+            const page = await browser.newPage();
+            if (viewport) {
+                await page.setViewport(viewport);
+            }
+            const id = String(nextTabId++);
+            tabs.set(id, page);
+            await watchPage(id, page);
+            // The new tab becomes the active one, like in a desktop browser
+            activateTab(id);
+            logDebug(`Opened tab ${id}`);
+            callback(null, await describeTab(id, page));
         } catch (error) {
             logDebug('Error in openTab:', (error as Error).message);
             callback({
@@ -88,11 +727,74 @@ const browserControlHandlers: BrowserControlServer = {
         }
     },
 
+    listTabs: async (_call: ServerUnaryCall<Empty, TabList>, callback: sendUnaryData<TabList>) => {
+        try {
+            const list: Tab[] = [];
+            for (const [id, page] of tabs) {
+                list.push(await describeTab(id, page));
+            }
+            callback(null, { tabs: list });
+        } catch (error) {
+            logDebug('Error in listTabs:', (error as Error).message);
+            callback({
+                code: grpc.status.INTERNAL,
+                message: `Failed to list tabs: ${(error as Error).message}`,
+            });
+        }
+    },
+
+    switchTab: async (call: ServerUnaryCall<TabRequest, Tab>, callback: sendUnaryData<Tab>) => {
+        try {
+            const page = getTab(call.request.tabId);
+            activateTab(call.request.tabId || activeTabId);
+            // Background tabs may be throttled; bring the new one to the front so it renders at full speed
+            await page.bringToFront();
+            logDebug(`Switched to tab ${activeTabId}`);
+            callback(null, await describeTab(activeTabId, page));
+        } catch (error) {
+            logDebug('Error in switchTab:', (error as Error).message);
+            callback({
+                code: grpc.status.INTERNAL,
+                message: `Failed to switch tab: ${(error as Error).message}`,
+            });
+        }
+    },
+
+    closeTab: async (call: ServerUnaryCall<TabRequest, Message>, callback: sendUnaryData<Message>) => {
+        try {
+            const id = call.request.tabId || activeTabId;
+            const page = getTab(id);
+            if (tabs.size === 1) {
+                callback({
+                    code: grpc.status.FAILED_PRECONDITION,
+                    message: 'Cannot close the last tab',
+                });
+                return;
+            }
+
+            // Closing the active tab activates its neighbour, preferring the one after it
+            if (id === activeTabId) {
+                const ids = [...tabs.keys()];
+                const index = ids.indexOf(id);
+                activateTab(ids[index + 1] ?? ids[index - 1]);
+                await getTab(activeTabId).bringToFront();
+            }
+            tabs.delete(id);
+            await page.close();
+            logDebug(`Closed tab ${id}, active tab is ${activeTabId}`);
+            callback(null, { text: `Closed tab ${id}` });
+        } catch (error) {
+            logDebug('Error in closeTab:', (error as Error).message);
+            callback({
+                code: grpc.status.INTERNAL,
+                message: `Failed to close tab: ${(error as Error).message}`,
+            });
+        }
+    },
+
     setViewport: async (call: ServerUnaryCall<ViewportSize, Message>, callback: sendUnaryData<Message>) => {
         try {
-            if (!page) throw new Error('No active page');
-            const { width, height } = call.request;
-            await page.setViewport({ width, height });
+            await applyViewport(call.request);
             callback(null, { text: 'Viewport set' });
         } catch (error) {
             logDebug('Error in setViewport:', (error as Error).message);
@@ -105,7 +807,7 @@ const browserControlHandlers: BrowserControlServer = {
 
     clickMouse: async (call: ServerUnaryCall<Coordinate, Message>, callback: sendUnaryData<Message>) => {
         try {
-            if (!page) throw new Error('No active page');
+            const page = getTab(call.request.tabId);
             const { x, y } = call.request;
             await page.mouse.click(x, y);
             callback(null, { text: 'Mouse clicked' });
@@ -118,10 +820,61 @@ const browserControlHandlers: BrowserControlServer = {
         }
     },
 
+    mouseMove: async (call: ServerUnaryCall<MouseEvent, Message>, callback: sendUnaryData<Message>) => {
+        try {
+            await moveMouse(getTab(call.request.tabId), call.request);
+            callback(null, { text: 'Mouse moved' });
+        } catch (error) {
+            logDebug('Error in mouseMove:', (error as Error).message);
+            callback({
+                code: grpc.status.INTERNAL,
+                message: `Failed to move mouse: ${(error as Error).message}`,
+            });
+        }
+    },
+
+    mouseDown: async (call: ServerUnaryCall<MouseEvent, Message>, callback: sendUnaryData<Message>) => {
+        try {
+            await pressMouseButton(getTab(call.request.tabId), call.request);
+            callback(null, { text: 'Mouse button pressed' });
+        } catch (error) {
+            logDebug('Error in mouseDown:', (error as Error).message);
+            callback({
+                code: grpc.status.INTERNAL,
+                message: `Failed to press mouse button: ${(error as Error).message}`,
+            });
+        }
+    },
+
+    mouseUp: async (call: ServerUnaryCall<MouseEvent, Message>, callback: sendUnaryData<Message>) => {
+        try {
+            await releaseMouseButton(getTab(call.request.tabId), call.request);
+            callback(null, { text: 'Mouse button released' });
+        } catch (error) {
+            logDebug('Error in mouseUp:', (error as Error).message);
+            callback({
+                code: grpc.status.INTERNAL,
+                message: `Failed to release mouse button: ${(error as Error).message}`,
+            });
+        }
+    },
+
+    mouseWheel: async (call: ServerUnaryCall<WheelEvent, Message>, callback: sendUnaryData<Message>) => {
+        try {
+            await scrollMouseWheel(getTab(call.request.tabId), call.request);
+            callback(null, { text: 'Mouse wheel scrolled' });
+        } catch (error) {
+            logDebug('Error in mouseWheel:', (error as Error).message);
+            callback({
+                code: grpc.status.INTERNAL,
+                message: `Failed to scroll mouse wheel: ${(error as Error).message}`,
+            });
+        }
+    },
+
     sendKeyboardInput: async (call: ServerUnaryCall<Text, Message>, callback: sendUnaryData<Message>) => {
         try {
-            if (!page) throw new Error('No active page');
-            await page.keyboard.type(call.request.content);
+            await typeText(getTab(call.request.tabId), call.request.content);
             callback(null, { text: 'Keyboard input sent' });
         } catch (error) {
             logDebug('Error in sendKeyboardInput:', (error as Error).message);
@@ -132,9 +885,50 @@ const browserControlHandlers: BrowserControlServer = {
         }
     },
 
+    sendKeyEvent: async (call: ServerUnaryCall<KeyEvent, Message>, callback: sendUnaryData<Message>) => {
+        try {
+            const page = getTab(call.request.tabId);
+            await dispatchKeyEvent(page, call.request);
+            callback(null, { text: 'Key event sent' });
+        } catch (error) {
+            logDebug('Error in sendKeyEvent:', (error as Error).message);
+            callback({
+                code: grpc.status.INTERNAL,
+                message: `Failed to send key event: ${(error as Error).message}`,
+            });
+        }
+    },
+
+    inputEvents: (call: ServerReadableStream<InputEvent, Message>, callback: sendUnaryData<Message>) => {
+        // Events are handled one at a time in the order they arrive. The stream is paused meanwhile,
+        // so flow control holds back a client that sends faster than the page takes input.
+        let handled = 0;
+        let queue = Promise.resolve();
+        call.on('data', (event: InputEvent) => {
+            call.pause();
+            queue = queue.then(async () => {
+                try {
+                    await handleInputEvent(event);
+                    handled++;
+                } catch (error) {
+                    // One bad event, e.g. for a tab just closed, doesn't end the stream
+                    logDebug('Error in inputEvents:', (error as Error).message);
+                } finally {
+                    call.resume();
+                }
+            });
+        });
+        call.on('end', () => {
+            queue.then(() => callback(null, { text: `Handled ${handled} input events` }));
+        });
+        call.on('error', (error) => {
+            logDebug('Input event stream failed:', error.message);
+        });
+    },
+
     navigateToUrl: async (call: ServerUnaryCall<Url, Message>, callback: sendUnaryData<Message>) => {
         try {
-            if (!page) throw new Error('No active page');
+            const page = getTab(call.request.tabId);
             await page.goto(call.request.url);
             callback(null, { text: 'Navigated to URL' });
         } catch (error) {
@@ -146,14 +940,137 @@ const browserControlHandlers: BrowserControlServer = {
         }
     },
 
+    getCurrentUrl: async (call: ServerUnaryCall<TabRequest, Url>, callback: sendUnaryData<Url>) => {
+        try {
+            const page = getTab(call.request.tabId);
+            callback(null, { url: page.url() });
+        } catch (error) {
+            logDebug('Error in getCurrentUrl:', (error as Error).message);
+            callback({
+                code: grpc.status.INTERNAL,
+                message: `Failed to get current URL: ${(error as Error).message}`,
+            });
+        }
+    },
+
+    goBack: async (call: ServerUnaryCall<TabRequest, Message>, callback: sendUnaryData<Message>) => {
+        try {
+            const page = getTab(call.request.tabId);
+            // goBack resolves to null when there is no history to go back to
+            const response = await page.goBack();
+            callback(null, { text: response ? `Went back to ${page.url()}` : 'No previous page' });
+        } catch (error) {
+            logDebug('Error in goBack:', (error as Error).message);
+            callback({
+                code: grpc.status.INTERNAL,
+                message: `Failed to go back: ${(error as Error).message}`,
+            });
+        }
+    },
+
+    goForward: async (call: ServerUnaryCall<TabRequest, Message>, callback: sendUnaryData<Message>) => {
+        try {
+            const page = getTab(call.request.tabId);
+            const response = await page.goForward();
+            callback(null, { text: response ? `Went forward to ${page.url()}` : 'No next page' });
+        } catch (error) {
+            logDebug('Error in goForward:', (error as Error).message);
+            callback({
+                code: grpc.status.INTERNAL,
+                message: `Failed to go forward: ${(error as Error).message}`,
+            });
+        }
+    },
+
+    reload: async (call: ServerUnaryCall<ReloadRequest, Message>, callback: sendUnaryData<Message>) => {
+        try {
+            const page = getTab(call.request.tabId);
+            if (call.request.ignoreCache) {
+                // Puppeteer's reload always uses the cache, so go through the DevTools protocol
+                const client = await page.createCDPSession();
+                try {
+                    await Promise.all([
+                        page.waitForNavigation(),
+                        client.send('Page.reload', { ignoreCache: true }),
+                    ]);
+                } finally {
+                    await client.detach();
+                }
+            } else {
+                await page.reload();
+            }
+            const how = call.request.ignoreCache ? ' bypassing the cache' : '';
+            callback(null, { text: `Reloaded ${page.url()}${how}` });
+        } catch (error) {
+            logDebug('Error in reload:', (error as Error).message);
+            callback({
+                code: grpc.status.INTERNAL,
+                message: `Failed to reload: ${(error as Error).message}`,
+            });
+        }
+    },
+
+    stopLoading: async (call: ServerUnaryCall<TabRequest, Message>, callback: sendUnaryData<Message>) => {
+        try {
+            const page = getTab(call.request.tabId);
+            const client = await page.createCDPSession();
+            try {
+                await client.send('Page.stopLoading');
+            } finally {
+                await client.detach();
+            }
+            callback(null, { text: `Stopped loading ${page.url()}` });
+        } catch (error) {
+            logDebug('Error in stopLoading:', (error as Error).message);
+            callback({
+                code: grpc.status.INTERNAL,
+                message: `Failed to stop loading: ${(error as Error).message}`,
+            });
+        }
+    },
 
     streamScreenshots: async (call: ServerWritableStream<ScreenshotRequest, Screenshot>) => {
         const fps = call.request.fps || 10;
         const interval = 1000 / fps;
+        const frames = frameStream(call.request);
+
+        if (call.request.screencast) {
+            logDebug(`Starting screencast stream at up to ${fps} FPS`);
+            const screencast = screencastFrames(call.request, frames, fps, async (frame) => {
+                if (!call.write({ ...frame, inputSeq: 0 })) {
+                    logDebug('Stream backpressure detected');
+                    if (!await drained(call)) {
+                        screencast.stop();
+                    }
+                }
+            });
+            call.on('close', () => {
+                screencast.stop();
+            });
+            call.on('cancelled', () => {
+                logDebug('Stream cancelled by client');
+                screencast.stop();
+            });
+            call.on('error', (err) => {
+                logDebug('Stream error:', err.message);
+                screencast.stop();
+            });
+            return;
+        }
+
         logDebug(`Starting screenshot stream at ${fps} FPS`);
 
+        let capturing = false;
         const intervalId = setInterval(async () => {
+            // Skip ticks while the last capture runs, slow captures would pile up otherwise
+            if (capturing) {
+                return;
+            }
+            capturing = true;
             try {
+                // Look the tab up every frame so a stream without a tab ID follows tab switches
+                const tabId = call.request.tabId || activeTabId;
+                const page = tabs.get(tabId);
                 if (!page) {
                     logDebug('No active page, stopping stream');
                     clearInterval(intervalId);
@@ -161,14 +1078,8 @@ const browserControlHandlers: BrowserControlServer = {
                     return;
                 }
 
-                const screenshot = await page.screenshot({ 
-                    type: 'jpeg',
-                    quality: 60
-                });
-                const screenshotBuffer = Buffer.from(screenshot);
-
                 // Write to stream
-                const success = call.write({ data: screenshotBuffer });
+                const success = call.write({ ...await frames.capture(page, tabId), inputSeq: 0 });
                 if (!success) {
                     logDebug('Stream backpressure detected');
                 }
@@ -176,6 +1087,8 @@ const browserControlHandlers: BrowserControlServer = {
                 logDebug('Error in streamScreenshots:', (error as Error).message);
                 clearInterval(intervalId);
                 call.destroy(error as Error);
+            } finally {
+                capturing = false;
             }
         }, interval);
 
@@ -190,6 +1103,189 @@ const browserControlHandlers: BrowserControlServer = {
             clearInterval(intervalId);
         });
     },
+
+    pageEvents: async (call: ServerWritableStream<TabRequest, PageEvent>) => {
+        const tabId = call.request.tabId;
+        logDebug(`Starting page event stream for ${tabId ? `tab ${tabId}` : 'all tabs'}`);
+
+        const send = (event: PageEvent) => {
+            if (!tabId || event.tabId === tabId) {
+                call.write(event);
+            }
+        };
+
+        // Start with where every tab is at, then follow the changes
+        pageStatusSnapshots().forEach(send);
+        pageEvents.on('event', send);
+
+        const stop = () => {
+            pageEvents.off('event', send);
+        };
+        call.on('cancelled', () => {
+            logDebug('Page event stream cancelled by client');
+            stop();
+        });
+        call.on('error', (err) => {
+            logDebug('Page event stream error:', err.message);
+            stop();
+        });
+    },
+
+    session: (call: ServerDuplexStream<ClientMessage, ServerMessage>) => {
+        logDebug('Session started');
+        let open = true;
+        let seq = 0;
+        // seq of the last client message handled, sent with frames so the client can tell which
+        // input a frame shows the result of
+        let handledSeq = 0;
+        const send = (message: Omit<ServerMessage, 'seq'>): boolean => {
+            return open && call.write({ seq: ++seq, ...message });
+        };
+
+        // Page events of every tab, starting with where each tab is at
+        const sendPageEvent = (event: PageEvent) => {
+            send({ pageEvent: event });
+        };
+        pageStatusSnapshots().forEach(sendPageEvent);
+        pageEvents.on('event', sendPageEvent);
+
+        // Frames, while the client asks for them. They come from a screenshot timer, or from the
+        // screencast when the client asked for that.
+        let frameTimer: NodeJS.Timeout | null = null;
+        let frames: { stream: FrameStream; tabId: string; screencast: Screencast | null } | null = null;
+        let capturing = false;
+        let draining = false;
+        const clearFrameTimer = () => {
+            if (frameTimer) {
+                clearInterval(frameTimer);
+                frameTimer = null;
+            }
+        };
+        const stopFrames = () => {
+            clearFrameTimer();
+            frames?.screencast?.stop();
+            frames = null;
+        };
+        // Sends the frames started at the given rate, or pauses them at 0. Changing the rate keeps the
+        // frame stream, so no keyframe is needed.
+        const runFrames = (fps: number) => {
+            clearFrameTimer();
+            if (frames?.screencast) {
+                frames.screencast.setFps(fps);
+                return;
+            }
+            if (!frames || fps <= 0) {
+                return;
+            }
+            const { stream, tabId } = frames;
+            frameTimer = setInterval(async () => {
+                // Skip ticks while the last capture runs or the client is not keeping up
+                if (capturing || draining) {
+                    return;
+                }
+                // Look the tab up every frame so frames without a tab ID follow tab switches
+                const id = tabId || activeTabId;
+                const page = tabs.get(id);
+                if (!page) {
+                    return;
+                }
+                capturing = true;
+                try {
+                    const inputSeq = handledSeq;
+                    if (!send({ frame: { ...await stream.capture(page, id), inputSeq } })) {
+                        draining = true;
+                        call.once('drain', () => {
+                            draining = false;
+                        });
+                    }
+                } catch (error) {
+                    logDebug('Error capturing session frame:', (error as Error).message);
+                } finally {
+                    capturing = false;
+                }
+            }, 1000 / fps);
+        };
+        // Screencast frames wait for the client to catch up instead of being skipped, the
+        // screencast sends no other frame of a page that stopped changing
+        const sendScreencastFrame = async (frame: Omit<Screenshot, 'inputSeq'>) => {
+            if (!send({ frame: { ...frame, inputSeq: handledSeq } }) && !await drained(call)) {
+                stop();
+            }
+        };
+        const startFrames = (request: ScreenshotRequest) => {
+            const { fps, tabId, format, deltas, screencast } = request;
+            logDebug(`Session frames at ${fps || 10} FPS as ${frameFormatToJSON(format)}${deltas ? ' with deltas' : ''}` +
+                (screencast ? ' from the screencast' : ''));
+            stopFrames();
+            const stream = frameStream(request);
+            frames = {
+                stream,
+                tabId,
+                screencast: screencast ? screencastFrames(request, stream, fps || 10, sendScreencastFrame) : null,
+            };
+            runFrames(fps || 10);
+        };
+        const setFrameRate = (fps: number) => {
+            logDebug(fps > 0 ? `Session frames at ${fps} FPS` : 'Session frames paused');
+            runFrames(fps);
+        };
+
+        const handle = async (message: ClientMessage) => {
+            if (message.input) {
+                await handleInputEvent(message.input);
+            } else if (message.viewport) {
+                await applyViewport(message.viewport);
+            } else if (message.control?.startFrames) {
+                startFrames(message.control.startFrames);
+            } else if (message.control?.stopFrames) {
+                stopFrames();
+            } else if (message.control?.requestKeyframe) {
+                frames?.stream.requestKeyframe();
+                // The screencast might not send another frame for a while
+                frames?.screencast?.refresh();
+            } else if (message.control?.setFps !== undefined) {
+                setFrameRate(message.control.setFps);
+            }
+        };
+
+        // Client messages are handled one at a time in the order they arrive, like inputEvents
+        let queue = Promise.resolve();
+        call.on('data', (message: ClientMessage) => {
+            call.pause();
+            queue = queue.then(async () => {
+                let error = '';
+                try {
+                    await handle(message);
+                } catch (e) {
+                    error = (e as Error).message;
+                    logDebug('Error in session:', error);
+                }
+                handledSeq = message.seq;
+                send({ ack: { seq: message.seq, error } });
+                call.resume();
+            });
+        });
+
+        const stop = () => {
+            open = false;
+            stopFrames();
+            pageEvents.off('event', sendPageEvent);
+        };
+        call.on('end', () => {
+            logDebug('Session ended by client');
+            stop();
+            call.end();
+        });
+        call.on('close', stop);
+        call.on('cancelled', () => {
+            logDebug('Session cancelled by client');
+            stop();
+        });
+        call.on('error', (err) => {
+            logDebug('Session error:', err.message);
+            stop();
+        });
+    },
 };
 
 // gRPC server setup